@@ -0,0 +1,211 @@
+package htf
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Daily aggregation policies. AggregatePolicyAll keeps every reading; the
+// others reduce each day (in JST) to a single reading.
+const (
+	AggregatePolicyAll    = "all"
+	AggregatePolicyFirst  = "first"
+	AggregatePolicyLast   = "last"
+	AggregatePolicyMin    = "min"
+	AggregatePolicyMean   = "mean"
+	AggregatePolicyMedian = "median"
+)
+
+// ParseAggregatePolicy validates an aggregation policy. An empty policy is
+// AggregatePolicyAll.
+func ParseAggregatePolicy(s string) (string, error) {
+	switch s {
+	case "":
+		return AggregatePolicyAll, nil
+	case AggregatePolicyAll, AggregatePolicyFirst, AggregatePolicyLast, AggregatePolicyMin, AggregatePolicyMean, AggregatePolicyMedian:
+		return s, nil
+	default:
+		return "", errors.Errorf("unknown aggregation policy: %s", s)
+	}
+}
+
+// AggregateDaily reduces data to one reading per day according to policy.
+// first, last and min pick an actual reading (by weight, so the weight and
+// fat of a day always come from the same measurement). mean and median are
+// computed per metric and dated at the first reading of the day.
+func AggregateDaily(data AggregatedInnerScanDataMap, policy string) (AggregatedInnerScanDataMap, error) {
+	policy, err := ParseAggregatePolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	if policy == AggregatePolicyAll {
+		return data, nil
+	}
+
+	days := map[string][]time.Time{}
+	for t := range data {
+		day := t.In(tz).Format("2006-01-02")
+		days[day] = append(days[day], t)
+	}
+
+	result := make(AggregatedInnerScanDataMap, len(days))
+	for _, times := range days {
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+		// Readings without weight are only used when the day has nothing else
+		weighed := times[:0:0]
+		for _, t := range times {
+			if data[t].Weight != nil {
+				weighed = append(weighed, t)
+			}
+		}
+		if len(weighed) == 0 {
+			weighed = times
+		}
+
+		switch policy {
+		case AggregatePolicyFirst:
+			t := weighed[0]
+			result[t] = data[t]
+		case AggregatePolicyLast:
+			t := weighed[len(weighed)-1]
+			result[t] = data[t]
+		case AggregatePolicyMin:
+			t := weighed[0]
+			for _, u := range weighed[1:] {
+				// A day with only fat readings keeps its first one
+				if data[u].Weight != nil && *data[u].Weight < *data[t].Weight {
+					t = u
+				}
+			}
+			result[t] = data[t]
+		case AggregatePolicyMean, AggregatePolicyMedian:
+			reduce := mean
+			if policy == AggregatePolicyMedian {
+				reduce = median
+			}
+
+			var weights, fats []float64
+			for _, t := range times {
+				if w := data[t].Weight; w != nil {
+					weights = append(weights, *w)
+				}
+				if f := data[t].Fat; f != nil {
+					fats = append(fats, *f)
+				}
+			}
+
+			first := data[times[0]]
+			d := &AggregatedInnerScanData{Model: first.Model, Profile: first.Profile}
+			if len(weights) > 0 {
+				v := math.Round(reduce(weights)*100) / 100
+				d.Weight = &v
+			}
+			if len(fats) > 0 {
+				v := math.Round(reduce(fats)*100) / 100
+				d.Fat = &v
+			}
+			result[times[0]] = d
+		}
+	}
+
+	return result, nil
+}
+
+// DailyKey returns the key identifying the reading at t once aggregated with
+// policy: the time itself for AggregatePolicyAll, the day (in JST) otherwise.
+func DailyKey(policy string, t time.Time) string {
+	if policy == "" || policy == AggregatePolicyAll {
+		return t.In(tz).Format("2006-01-02 15:04:05")
+	}
+	return t.In(tz).Format("2006-01-02")
+}
+
+// DayComplete reports whether the aggregated reading at t can no longer
+// change, that is whether its day is over or policy does not depend on later
+// readings of the same day.
+func DayComplete(policy string, t, now time.Time) bool {
+	switch policy {
+	case "", AggregatePolicyAll, AggregatePolicyFirst:
+		return true
+	}
+	d := t.In(tz)
+	end := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, tz).AddDate(0, 0, 1)
+	return !now.Before(end)
+}
+
+// HasWeightLog reports whether Fitbit already has the weight of the reading
+// at t. With AggregatePolicyAll only a log at the same time counts; otherwise
+// any log on the same day (in JST) does, since the day has a single
+// canonical entry.
+func (api *FitbitAPI) HasWeightLog(ctx context.Context, policy string, t time.Time) (bool, error) {
+	from, to := logWindow(policy, t)
+	res, err := api.GetBodyWeightLogRange(ctx, from.UTC(), to.UTC())
+	if err != nil {
+		return false, err
+	}
+
+	for _, w := range res.Weight {
+		if inLogWindow(w.Date, w.Time, from, to) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// HasFatLog is HasWeightLog for the fat.
+func (api *FitbitAPI) HasFatLog(ctx context.Context, policy string, t time.Time) (bool, error) {
+	from, to := logWindow(policy, t)
+	res, err := api.GetBodyFatLogRange(ctx, from.UTC(), to.UTC())
+	if err != nil {
+		return false, err
+	}
+
+	for _, f := range res.Fat {
+		if inLogWindow(f.Date, f.Time, from, to) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// logWindow returns the times a log of the reading at t may have.
+func logWindow(policy string, t time.Time) (time.Time, time.Time) {
+	if policy == "" || policy == AggregatePolicyAll {
+		return t, t
+	}
+	from := startOfDay(t)
+	return from, from.AddDate(0, 0, 1).Add(-time.Second)
+}
+
+func inLogWindow(date, clock string, from, to time.Time) bool {
+	t, err := parseFitbitTime(date, clock)
+	if err != nil {
+		return false
+	}
+	return !t.Before(from) && !t.After(to)
+}
+
+func mean(vs []float64) float64 {
+	sum := 0.0
+	for _, v := range vs {
+		sum += v
+	}
+	return sum / float64(len(vs))
+}
+
+func median(vs []float64) float64 {
+	s := append([]float64(nil), vs...)
+	sort.Float64s(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
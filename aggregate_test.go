@@ -0,0 +1,154 @@
+package htf
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAggregateDaily(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	t1 := time.Date(2023, 1, 1, 7, 0, 0, 0, tz)
+	t2 := time.Date(2023, 1, 1, 12, 0, 0, 0, tz)
+	t3 := time.Date(2023, 1, 1, 22, 0, 0, 0, tz)
+	t4 := time.Date(2023, 1, 2, 7, 0, 0, 0, tz)
+	data := AggregatedInnerScanDataMap{
+		t1: {Weight: f(70.0), Fat: f(20.0)},
+		t2: {Weight: f(69.5), Fat: f(21.0)},
+		t3: {Weight: f(71.5), Fat: f(22.5)},
+		t4: {Weight: f(70.2)},
+	}
+
+	tests := []struct {
+		policy string
+		time   time.Time
+		weight float64
+		fat    float64
+	}{
+		{AggregatePolicyFirst, t1, 70.0, 20.0},
+		{AggregatePolicyLast, t3, 71.5, 22.5},
+		{AggregatePolicyMin, t2, 69.5, 21.0},
+		{AggregatePolicyMean, t1, 70.33, 21.17},
+		{AggregatePolicyMedian, t1, 70.0, 21.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			got, err := AggregateDaily(data, tt.policy)
+			if err != nil {
+				t.Fatalf("AggregateDaily() error = %v", err)
+			}
+			if len(got) != 2 {
+				t.Fatalf("AggregateDaily() = %d readings, want 2", len(got))
+			}
+			d, ok := got[tt.time]
+			if !ok {
+				t.Fatalf("AggregateDaily() has no reading at %s", tt.time)
+			}
+			if *d.Weight != tt.weight || *d.Fat != tt.fat {
+				t.Errorf("reading = %v/%v, want %v/%v", *d.Weight, *d.Fat, tt.weight, tt.fat)
+			}
+			if d := got[t4]; d == nil || *d.Weight != 70.2 || d.Fat != nil {
+				t.Errorf("second day = %+v", d)
+			}
+		})
+	}
+
+	got, err := AggregateDaily(data, AggregatePolicyAll)
+	if err != nil || len(got) != 4 {
+		t.Errorf("AggregateDaily(all) = %d readings, %v", len(got), err)
+	}
+	if _, err := AggregateDaily(data, "max"); err == nil {
+		t.Error("AggregateDaily(max) error = nil")
+	}
+}
+
+func TestAggregateDaily_FatOnly(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	t1 := time.Date(2023, 1, 1, 7, 0, 0, 0, tz)
+	t2 := time.Date(2023, 1, 1, 22, 0, 0, 0, tz)
+	data := AggregatedInnerScanDataMap{
+		t1: {Fat: f(20.0)},
+		t2: {Fat: f(21.0)},
+	}
+
+	tests := []struct {
+		policy string
+		time   time.Time
+		fat    float64
+	}{
+		{AggregatePolicyFirst, t1, 20.0},
+		{AggregatePolicyLast, t2, 21.0},
+		{AggregatePolicyMin, t1, 20.0},
+		{AggregatePolicyMean, t1, 20.5},
+		{AggregatePolicyMedian, t1, 20.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			got, err := AggregateDaily(data, tt.policy)
+			if err != nil {
+				t.Fatalf("AggregateDaily() error = %v", err)
+			}
+			d, ok := got[tt.time]
+			if len(got) != 1 || !ok {
+				t.Fatalf("AggregateDaily() = %v, want a reading at %s", got, tt.time)
+			}
+			if d.Weight != nil || d.Fat == nil || *d.Fat != tt.fat {
+				t.Errorf("reading = %+v, want fat %v", d, tt.fat)
+			}
+		})
+	}
+}
+
+func TestDayComplete(t *testing.T) {
+	reading := time.Date(2023, 1, 1, 7, 0, 0, 0, tz)
+	sameDay := time.Date(2023, 1, 1, 23, 0, 0, 0, tz)
+	nextDay := time.Date(2023, 1, 2, 0, 0, 0, 0, tz)
+
+	if !DayComplete(AggregatePolicyFirst, reading, sameDay) {
+		t.Error("first should be complete on the same day")
+	}
+	if DayComplete(AggregatePolicyMean, reading, sameDay) {
+		t.Error("mean should not be complete on the same day")
+	}
+	if !DayComplete(AggregatePolicyMean, reading, nextDay) {
+		t.Error("mean should be complete on the next day")
+	}
+}
+
+func TestHasWeightLog(t *testing.T) {
+	// 2023-01-01 07:00 JST is 2022-12-31 22:00 UTC
+	reading := time.Date(2023, 1, 1, 7, 0, 0, 0, tz)
+	body := `{"weight":[{"date":"2022-12-31","time":"23:30:00","weight":70.1,"logId":1}]}`
+
+	var paths []string
+	api := &FitbitAPI{Client: NewTestClient(func(req *http.Request) *http.Response {
+		paths = append(paths, req.URL.Path)
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})}
+
+	found, err := api.HasWeightLog(context.Background(), AggregatePolicyAll, reading)
+	if err != nil {
+		t.Fatalf("HasWeightLog(all) error = %v", err)
+	}
+	if found {
+		t.Error("HasWeightLog(all) = true for a log at another time")
+	}
+
+	found, err = api.HasWeightLog(context.Background(), AggregatePolicyMin, reading)
+	if err != nil {
+		t.Fatalf("HasWeightLog(min) error = %v", err)
+	}
+	if !found {
+		t.Error("HasWeightLog(min) = false for a log on the same day")
+	}
+	if want := "/1/user/-/body/log/weight/date/2022-12-31/2023-01-01.json"; paths[1] != want {
+		t.Errorf("path = %s, want %s", paths[1], want)
+	}
+}
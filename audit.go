@@ -0,0 +1,184 @@
+package htf
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	AuditMissingInFitbit = "missing_in_fitbit"
+	AuditUnknownInFitbit = "unknown_in_fitbit"
+	AuditMismatch        = "mismatch"
+)
+
+// auditTolerance is the largest difference between HealthPlanet and Fitbit
+// values that is not reported as a mismatch (Fitbit may round values).
+const auditTolerance = 0.05
+
+type AuditEntry struct {
+	Kind         string    `json:"kind"`
+	Time         time.Time `json:"time"`
+	Metric       string    `json:"metric"`
+	HealthPlanet *float64  `json:"healthplanet,omitempty"`
+	Fitbit       *float64  `json:"fitbit,omitempty"`
+	LogId        int64     `json:"log_id,omitempty"`
+}
+
+// AuditReport is the result of joining HealthPlanet readings against Fitbit logs.
+type AuditReport struct {
+	// MissingInFitbit lists HealthPlanet readings with no Fitbit log.
+	MissingInFitbit []AuditEntry `json:"missing_in_fitbit"`
+	// UnknownInFitbit lists Fitbit logs with no HealthPlanet reading.
+	UnknownInFitbit []AuditEntry `json:"unknown_in_fitbit"`
+	// Mismatches lists readings whose values differ between both sides.
+	Mismatches []AuditEntry `json:"mismatches"`
+}
+
+type auditKey struct {
+	metric string
+	unix   int64
+}
+
+// AuditDaily reduces data with the daily aggregation policy the sync used
+// (see AggregateDaily) and audits the result, so that a day Fitbit has a
+// single log for is compared with the reading the sync wrote.
+func AuditDaily(data AggregatedInnerScanDataMap, logs []FitbitBodyLog, policy string) (AuditReport, error) {
+	daily, err := AggregateDaily(data, policy)
+	if err != nil {
+		return AuditReport{}, err
+	}
+	return Audit(daily, logs), nil
+}
+
+// Audit compares HealthPlanet readings with Fitbit weight and fat logs
+// recorded at the same time. Fitbit weights are converted to kilograms.
+func Audit(data AggregatedInnerScanDataMap, logs []FitbitBodyLog) AuditReport {
+	report := AuditReport{
+		MissingInFitbit: []AuditEntry{},
+		UnknownInFitbit: []AuditEntry{},
+		Mismatches:      []AuditEntry{},
+	}
+
+	index := make(map[auditKey][]int, len(logs))
+	for i, l := range logs {
+		k := auditKey{l.Type, l.Time.Unix()}
+		index[k] = append(index[k], i)
+	}
+	matched := make([]bool, len(logs))
+
+	times := data.Times()
+
+	check := func(t time.Time, metric string, value *float64) {
+		if value == nil {
+			return
+		}
+
+		candidates := index[auditKey{metric, t.Unix()}]
+		if len(candidates) == 0 {
+			report.MissingInFitbit = append(report.MissingInFitbit, AuditEntry{
+				Kind:         AuditMissingInFitbit,
+				Time:         t,
+				Metric:       metric,
+				HealthPlanet: value,
+			})
+			return
+		}
+
+		i := candidates[0]
+		index[auditKey{metric, t.Unix()}] = candidates[1:]
+		matched[i] = true
+
+		fitbitValue := logs[i].metricValue()
+		if math.Abs(fitbitValue-*value) > auditTolerance {
+			report.Mismatches = append(report.Mismatches, AuditEntry{
+				Kind:         AuditMismatch,
+				Time:         t,
+				Metric:       metric,
+				HealthPlanet: value,
+				Fitbit:       &fitbitValue,
+				LogId:        logs[i].LogId,
+			})
+		}
+	}
+
+	for _, t := range times {
+		check(t, FitbitLogTypeWeight, data[t].Weight)
+		check(t, FitbitLogTypeFat, data[t].Fat)
+	}
+
+	for i, l := range logs {
+		if matched[i] {
+			continue
+		}
+		value := l.metricValue()
+		report.UnknownInFitbit = append(report.UnknownInFitbit, AuditEntry{
+			Kind:   AuditUnknownInFitbit,
+			Time:   l.Time,
+			Metric: l.Type,
+			Fitbit: &value,
+			LogId:  l.LogId,
+		})
+	}
+
+	return report
+}
+
+// Entries returns all entries of the report in a single list.
+func (r AuditReport) Entries() []AuditEntry {
+	var entries []AuditEntry
+	entries = append(entries, r.MissingInFitbit...)
+	entries = append(entries, r.UnknownInFitbit...)
+	entries = append(entries, r.Mismatches...)
+	return entries
+}
+
+// WriteAudit writes the report to w in one of the export formats. CSV and
+// JSON Lines flatten the three lists, distinguished by the kind column.
+func WriteAudit(w io.Writer, format string, report AuditReport) error {
+	switch format {
+	case ExportFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"kind", "time", "metric", "healthplanet", "fitbit", "log_id"}); err != nil {
+			return errors.Wrap(err, "failed to write csv header")
+		}
+		for _, e := range report.Entries() {
+			logId := ""
+			if e.LogId != 0 {
+				logId = strconv.FormatInt(e.LogId, 10)
+			}
+			row := []string{
+				e.Kind,
+				e.Time.Format(time.RFC3339),
+				e.Metric,
+				formatOptionalFloat(e.HealthPlanet),
+				formatOptionalFloat(e.Fitbit),
+				logId,
+			}
+			if err := cw.Write(row); err != nil {
+				return errors.Wrap(err, "failed to write csv row")
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case ExportFormatJSONLines:
+		enc := json.NewEncoder(w)
+		for _, e := range report.Entries() {
+			if err := enc.Encode(e); err != nil {
+				return errors.Wrap(err, "failed to write json line")
+			}
+		}
+		return nil
+	case ExportFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	default:
+		return errors.Errorf("unknown export format: %s", format)
+	}
+}
@@ -0,0 +1,149 @@
+package htf
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	t1 := time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC)
+	t2 := time.Date(2023, 1, 2, 3, 0, 0, 0, time.UTC)
+	t3 := time.Date(2023, 1, 3, 3, 0, 0, 0, time.UTC)
+	weight1, fat1, weight2 := 70.5, 20.5, 70.1
+
+	data := AggregatedInnerScanDataMap{
+		t1: {Weight: &weight1, Fat: &fat1},
+		t2: {Weight: &weight2},
+	}
+	logs := []FitbitBodyLog{
+		{Type: FitbitLogTypeWeight, LogId: 1, Time: t1, Value: 70.5},
+		{Type: FitbitLogTypeFat, LogId: 2, Time: t1, Value: 21.5},
+		{Type: FitbitLogTypeWeight, LogId: 3, Time: t3, Value: 69.9},
+	}
+
+	report := Audit(data, logs)
+
+	if len(report.MissingInFitbit) != 1 || !report.MissingInFitbit[0].Time.Equal(t2) || report.MissingInFitbit[0].Metric != FitbitLogTypeWeight {
+		t.Errorf("MissingInFitbit = %+v", report.MissingInFitbit)
+	}
+	if len(report.UnknownInFitbit) != 1 || report.UnknownInFitbit[0].LogId != 3 {
+		t.Errorf("UnknownInFitbit = %+v", report.UnknownInFitbit)
+	}
+	if len(report.Mismatches) != 1 || report.Mismatches[0].LogId != 2 || *report.Mismatches[0].HealthPlanet != 20.5 || *report.Mismatches[0].Fitbit != 21.5 {
+		t.Errorf("Mismatches = %+v", report.Mismatches)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAudit(&buf, ExportFormatCSV, report); err != nil {
+		t.Fatalf("WriteAudit() error = %v", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 4 {
+		t.Errorf("WriteAudit() wrote %d lines, want 4:\n%s", lines, buf.String())
+	}
+}
+
+func TestAuditDaily(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	t1 := time.Date(2023, 1, 1, 7, 0, 0, 0, tz).UTC()
+	t2 := time.Date(2023, 1, 1, 22, 0, 0, 0, tz).UTC()
+	t3 := time.Date(2023, 1, 2, 7, 0, 0, 0, tz).UTC()
+	data := AggregatedInnerScanDataMap{
+		t1: {Weight: f(70.0), Fat: f(20.0)},
+		t2: {Weight: f(71.0), Fat: f(21.0)},
+		t3: {Weight: f(70.5)},
+	}
+
+	tests := []struct {
+		policy string
+		logs   []FitbitBodyLog
+	}{
+		// One log per day, at the time of the reading written
+		{AggregatePolicyFirst, []FitbitBodyLog{
+			{Type: FitbitLogTypeWeight, LogId: 1, Time: t1, Value: 70.0},
+			{Type: FitbitLogTypeFat, LogId: 2, Time: t1, Value: 20.0},
+			{Type: FitbitLogTypeWeight, LogId: 3, Time: t3, Value: 70.5},
+		}},
+		{AggregatePolicyLast, []FitbitBodyLog{
+			{Type: FitbitLogTypeWeight, LogId: 1, Time: t2, Value: 71.0},
+			{Type: FitbitLogTypeFat, LogId: 2, Time: t2, Value: 21.0},
+			{Type: FitbitLogTypeWeight, LogId: 3, Time: t3, Value: 70.5},
+		}},
+		// Means are logged at the time of the first reading of the day
+		{AggregatePolicyMean, []FitbitBodyLog{
+			{Type: FitbitLogTypeWeight, LogId: 1, Time: t1, Value: 70.5},
+			{Type: FitbitLogTypeFat, LogId: 2, Time: t1, Value: 20.5},
+			{Type: FitbitLogTypeWeight, LogId: 3, Time: t3, Value: 70.5},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			report, err := AuditDaily(data, tt.logs, tt.policy)
+			if err != nil {
+				t.Fatalf("AuditDaily() error = %v", err)
+			}
+			if len(report.MissingInFitbit) != 0 || len(report.UnknownInFitbit) != 0 || len(report.Mismatches) != 0 {
+				t.Errorf("report = %+v, want no differences", report)
+			}
+
+			// Auditing the raw readings reports the other readings of the day
+			if raw := Audit(data, tt.logs); len(raw.MissingInFitbit) == 0 {
+				t.Errorf("Audit() = %+v, want missing readings", raw)
+			}
+		})
+	}
+
+	if _, err := AuditDaily(data, nil, "max"); err == nil {
+		t.Error("AuditDaily(max) error = nil")
+	}
+}
+
+func TestFitbitAPI_ListBodyLogs(t *testing.T) {
+	weightResp := `{"weight":[{"bmi":24.4,"date":"2023-01-01","fat":20.5,"logId":1,"source":"API","time":"03:00:00","weight":70.5}]}`
+	fatResp := `{"fat":[{"date":"2023-01-01","fat":20.5,"logId":2,"source":"API","time":"03:00:00"}]}`
+
+	var paths []string
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		paths = append(paths, req.URL.Path)
+		body := fatResp
+		if strings.Contains(req.URL.Path, "/weight/") {
+			body = weightResp
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})
+
+	api := &FitbitAPI{Client: client}
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, 2, 15, 0, 0, 0, 0, time.UTC)
+
+	logs, err := api.ListBodyLogs(context.Background(), from, to)
+	if err != nil {
+		t.Fatalf("ListBodyLogs() error = %v", err)
+	}
+
+	wantPaths := []string{
+		"/1/user/-/body/log/weight/date/2023-01-01/2023-01-31.json",
+		"/1/user/-/body/log/fat/date/2023-01-01/2023-01-31.json",
+		"/1/user/-/body/log/weight/date/2023-02-01/2023-02-15.json",
+		"/1/user/-/body/log/fat/date/2023-02-01/2023-02-15.json",
+	}
+	if strings.Join(paths, " ") != strings.Join(wantPaths, " ") {
+		t.Errorf("requested paths = %v, want %v", paths, wantPaths)
+	}
+
+	// Each chunk returns the same two logs in this mock
+	if len(logs) != 4 {
+		t.Fatalf("ListBodyLogs() got %d logs, want 4", len(logs))
+	}
+	if logs[0].Type != FitbitLogTypeWeight || logs[0].BMI != 24.4 || logs[0].Source != "API" || !logs[0].Time.Equal(time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("logs[0] = %+v", logs[0])
+	}
+}
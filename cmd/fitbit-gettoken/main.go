@@ -2,44 +2,36 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/base64"
+	"flag"
 	"fmt"
-	"log"
-	"math/rand"
+	"log/slog"
 	"net/http"
 	"os"
 
 	"github.com/joho/godotenv"
-	"golang.org/x/oauth2"
 
 	htf "healthplanet-to-fitbit"
 	"healthplanet-to-fitbit/config"
 )
 
-func randomString(n int) string {
-	var letter = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+func main() {
+	logFormat := flag.String("log-format", htf.LogFormatText, "log format (text, json)")
+	logLevel := flag.String("log-level", "info", "log level (debug, info, warn, error)")
+	flag.Parse()
 
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = letter[rand.Intn(len(letter))]
+	logger, err := htf.NewLogger(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	return string(b)
-}
-
-func genCodeChallenge() (verifier string, challenge string) {
-	verifier = randomString(128)
-	sum := sha256.Sum256([]byte(verifier))
-	challenge = base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(sum[:])
-	return
-}
+	slog.SetDefault(logger)
 
-func main() {
 	_ = godotenv.Load(".env")
 
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
+		slog.Error("failed to load config", "error", err)
+		os.Exit(1)
 	}
 
 	clientID := cfg.Fitbit.ClientID
@@ -49,7 +41,8 @@ func main() {
 	if clientID == "" {
 		fmt.Print("Input Fitbit Client ID: ")
 		if _, err := fmt.Scan(&clientID); err != nil {
-			log.Fatalf("failed to scan client id: %v", err)
+			slog.Error("failed to scan client id", "error", err)
+			os.Exit(1)
 		}
 	}
 
@@ -60,23 +53,28 @@ func main() {
 	if clientSecret == "" {
 		fmt.Print("Input Fitbit Client Secret: ")
 		if _, err := fmt.Scan(&clientSecret); err != nil {
-			log.Fatalf("failed to scan client secret: %v", err)
+			slog.Error("failed to scan client secret", "error", err)
+			os.Exit(1)
 		}
 	}
 
-	conf := htf.GetFitbitConfig(clientID, clientSecret)
-
-	verifier, challenge := genCodeChallenge()
+	flow := htf.NewFitbitAuthFlow(htf.GetFitbitConfig(clientID, clientSecret))
 
 	server := &http.Server{Addr: ":8080"}
 	done := make(chan struct{})
 
 	http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		if !flow.ValidState(r.URL.Query().Get("state")) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "err: invalid state")
+			return
+		}
 		code := r.URL.Query().Get("code")
 
 		ctx := context.Background()
-		token, err := conf.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+		token, err := flow.Exchange(ctx, code)
 		if err != nil {
+			slog.Error("failed to exchange code", "provider", "fitbit", "error", err)
 			w.WriteHeader(500)
 			fmt.Fprintf(w, "err: %v", err)
 			return
@@ -86,37 +84,35 @@ func main() {
 		cfg.Fitbit.ClientSecret = clientSecret
 		cfg.Fitbit.AccessToken = token.AccessToken
 		cfg.Fitbit.RefreshToken = token.RefreshToken
+		cfg.Fitbit.Expiry = token.Expiry
 		if err := config.SaveConfig(cfg); err != nil {
-			fmt.Fprintf(w, "failed to save config: %v", err)
+			fmt.Fprintf(w, "failed to save config: %v", htf.RedactError(err))
 			return
 		}
 
-		fmt.Fprintf(w, "AccessToken: %s\n", token.AccessToken)
-		fmt.Fprintf(w, "RefreshToken: %s\n", token.RefreshToken)
+		// Tokens are only masked; they stay in the config file
+		fmt.Fprintf(w, "AccessToken: %s\n", htf.MaskSecret(token.AccessToken))
+		fmt.Fprintf(w, "RefreshToken: %s\n", htf.MaskSecret(token.RefreshToken))
 		fmt.Fprintf(w, "Credentials saved to config file. You can close this window.")
 		close(done)
 	})
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		url := conf.AuthCodeURL("state",
-			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
-			oauth2.SetAuthURLParam("code_challenge", challenge),
-			oauth2.AccessTypeOffline,
-		)
-
-		http.Redirect(w, r, url, http.StatusFound)
+		http.Redirect(w, r, flow.AuthCodeURL(), http.StatusFound)
 	})
 
 	fmt.Println("Open: http://localhost:8080")
 	go func() {
 		if err := server.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("failed to start server: %v", err)
+			slog.Error("failed to start server", "error", err)
+			os.Exit(1)
 		}
 	}()
 
 	<-done
 	if err := server.Shutdown(context.Background()); err != nil {
-		log.Fatalf("failed to shutdown server: %v", err)
+		slog.Error("failed to shutdown server", "error", err)
+		os.Exit(1)
 	}
 	fmt.Println("Token saved successfully. Exiting.")
 }
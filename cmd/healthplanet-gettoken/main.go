@@ -2,12 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"time"
 
+	htf "healthplanet-to-fitbit"
 	"healthplanet-to-fitbit/config"
 
 	"github.com/joho/godotenv"
@@ -22,11 +26,22 @@ type AuthorizeResponse struct {
 }
 
 func main() {
+	logFormat := flag.String("log-format", htf.LogFormatText, "log format (text, json)")
+	logLevel := flag.String("log-level", "info", "log level (debug, info, warn, error)")
+	flag.Parse()
+
+	logger, err := htf.NewLogger(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
 	_ = godotenv.Load(".env")
 
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		fmt.Printf("failed to load config: %v", err)
+		slog.Error("failed to load config", "error", err)
 		os.Exit(1)
 	}
 
@@ -37,7 +52,7 @@ func main() {
 	if healthPlanetClientId == "" {
 		fmt.Print("Input HealthPlanet Client ID: ")
 		if _, err := fmt.Scan(&healthPlanetClientId); err != nil {
-			fmt.Printf("failed to scan client id: %v\n", err)
+			slog.Error("failed to scan client id", "error", err)
 			os.Exit(1)
 		}
 	}
@@ -49,7 +64,7 @@ func main() {
 	if healthPlanetClientSecret == "" {
 		fmt.Print("Input HealthPlanet Client Secret: ")
 		if _, err := fmt.Scan(&healthPlanetClientSecret); err != nil {
-			fmt.Printf("failed to scan client secret: %v\n", err)
+			slog.Error("failed to scan client secret", "error", err)
 			os.Exit(1)
 		}
 	}
@@ -57,7 +72,7 @@ func main() {
 	values := url.Values{}
 	values.Add("client_id", healthPlanetClientId)
 	values.Add("redirect_uri", redirectURI)
-	values.Add("scope", "innerscan")
+	values.Add("scope", "innerscan,sphygmomanometer,pedometer")
 	values.Add("response_type", "code")
 
 	fmt.Printf("Authorize URL: %s\n", fmt.Sprintf("https://www.healthplanet.jp/oauth/auth?%s", values.Encode()))
@@ -66,7 +81,7 @@ func main() {
 	fmt.Print("Input code: ")
 	var code string
 	if _, err := fmt.Scan(&code); err != nil {
-		fmt.Printf("failed to scan code: %v\n", err)
+		slog.Error("failed to scan code", "error", err)
 		os.Exit(1)
 	}
 
@@ -79,36 +94,42 @@ func main() {
 
 	res, err := http.Post(fmt.Sprintf("https://www.healthplanet.jp/oauth/token?%s", values.Encode()), "application/json", nil)
 	if err != nil {
-		fmt.Printf("failed to get token: %+v", err)
+		// The URL in the error holds the client secret and the code
+		slog.Error("failed to get token", "provider", "healthplanet", "error", htf.RedactError(err))
 		os.Exit(1)
 	}
+	defer res.Body.Close()
 	if res.StatusCode < 200 || 400 <= res.StatusCode {
-		fmt.Printf("failed to get token(invalid status code): %d", res.StatusCode)
+		slog.Error("failed to get token", "provider", "healthplanet", "status_code", res.StatusCode)
 		os.Exit(1)
 	}
 
 	resBody, err := io.ReadAll(res.Body)
 	if err != nil {
-		fmt.Printf("failed to get token(read body error): %+v", err)
+		slog.Error("failed to read token response", "provider", "healthplanet", "error", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Response Body: %s\n", string(resBody))
-	fmt.Println("")
 	var resData AuthorizeResponse
 	if err = json.Unmarshal(resBody, &resData); err != nil {
-		fmt.Printf("failed to parse response: %v", err)
+		slog.Error("failed to parse response", "provider", "healthplanet", "error", err)
 		os.Exit(1)
 	}
 	cfg.HealthPlanet.ClientID = healthPlanetClientId
 	cfg.HealthPlanet.ClientSecret = healthPlanetClientSecret
 	cfg.HealthPlanet.AccessToken = resData.AccessToken
 	cfg.HealthPlanet.RefreshToken = resData.RefreshToken
+	if resData.ExpiresIn > 0 {
+		cfg.HealthPlanet.Expiry = time.Now().Add(time.Duration(resData.ExpiresIn) * time.Second)
+	}
 	if err := config.SaveConfig(cfg); err != nil {
-		fmt.Printf("failed to save config: %v", err)
+		slog.Error("failed to save config", "error", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("AccessToken: %s\n", resData.AccessToken)
+	fmt.Printf("AccessToken: %s\n", htf.MaskSecret(resData.AccessToken))
+	if !cfg.HealthPlanet.Expiry.IsZero() {
+		fmt.Printf("Expiry: %s\n", cfg.HealthPlanet.Expiry.Format(time.RFC3339))
+	}
 	fmt.Println("Credentials saved to config file.")
 }
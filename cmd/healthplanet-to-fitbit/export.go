@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	htf "healthplanet-to-fitbit"
+	"healthplanet-to-fitbit/config"
+)
+
+// runExport writes the HealthPlanet InnerScan, blood pressure or step history
+// to a file or stdout.
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	from := fs.String("from", "", "start date (YYYY-MM-DD, default: 3 months ago)")
+	to := fs.String("to", "", "end date (YYYY-MM-DD, default: today)")
+	format := fs.String("format", htf.ExportFormatCSV, "output format (csv, jsonl, json)")
+	output := fs.String("output", "", "output file (default: stdout)")
+	trend := fs.Bool("trend", false, "include the smoothed weight trend")
+	unit := fs.String("unit", "", "weight unit (kg, lb; default: config or kg)")
+	source := fs.String("source", "innerscan", "data to export (innerscan, sphygmomanometer, pedometer)")
+	requests := addRequestFlags(fs)
+	setupLogger := addLogFlags(fs)
+	_ = fs.Parse(args)
+	setupLogger()
+
+	cfg := loadConfig()
+	ctx, cancel := requests.context(cfg)
+	defer cancel()
+	weightUnit := weightUnitFlag(*unit, cfg)
+
+	healthPlanetAPI := newHealthPlanetAPI(cfg, requests, nil)
+
+	apiFrom, apiTo := apiDateRange(*from, *to)
+	var records []htf.ExportRecord
+	switch *source {
+	case "innerscan":
+		scanData, err := healthPlanetAPI.AggregateInnerScanData(ctx, apiFrom, apiTo)
+		if err != nil {
+			fatalAPI("failed to aggregate inner scan data", "healthplanet", err)
+		}
+
+		scanData.ApplyDerived()
+		if *trend {
+			applyTrend(cfg, scanData)
+		}
+		records = htf.ConvertExportUnit(htf.ExportRecords(scanData), weightUnit, rounding(cfg))
+	case "sphygmomanometer":
+		bpData, err := healthPlanetAPI.AggregateBloodPressureData(ctx, apiFrom, apiTo)
+		if err != nil {
+			fatalAPI("failed to aggregate blood pressure data", "healthplanet", err)
+		}
+		records = htf.BloodPressureExportRecords(bpData)
+	case "pedometer":
+		steps, err := healthPlanetAPI.AggregateDailySteps(ctx, apiFrom, apiTo)
+		if err != nil {
+			fatalAPI("failed to aggregate steps", "healthplanet", err)
+		}
+		records = htf.StepsExportRecords(steps)
+	default:
+		fatal("unknown source", "source", *source)
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fatal("failed to create output file", "path", *output, "error", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := htf.WriteExport(w, *format, records); err != nil {
+		fatal("failed to export", "error", err)
+	}
+
+	slog.Info("exported", "count", len(records))
+}
+
+// applyTrend sets the weight trend on data, computed over the ledger history
+// together with data so the first readings of the range are smoothed too.
+func applyTrend(cfg *config.Config, data htf.AggregatedInnerScanDataMap) {
+	ms, err := openLedger().ListMeasurements(context.Background(), time.Time{}, time.Now())
+	if err != nil {
+		fatal("failed to read ledger", "error", err)
+	}
+
+	weights := make(map[time.Time]float64, len(ms)+len(data))
+	for _, m := range ms {
+		if m.Weight != nil {
+			weights[m.Time] = *m.Weight
+		}
+	}
+	for t, d := range data {
+		if d.Weight != nil {
+			weights[t] = *d.Weight
+		}
+	}
+
+	trend, err := htf.WeightTrend(weights, trendAlpha(cfg))
+	if err != nil {
+		fatal("failed to compute trend", "error", err)
+	}
+	data.ApplyTrend(trend)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+
+	htf "healthplanet-to-fitbit"
+)
+
+// runFitbitExport dumps the Fitbit weight and fat logs, or audits them
+// against HealthPlanet.
+func runFitbitExport(args []string) {
+	fs := flag.NewFlagSet("fitbit-export", flag.ExitOnError)
+	from := fs.String("from", "", "start date (YYYY-MM-DD, default: 3 months ago)")
+	to := fs.String("to", "", "end date (YYYY-MM-DD, default: today)")
+	format := fs.String("format", htf.ExportFormatCSV, "output format (csv, jsonl, json)")
+	output := fs.String("output", "", "output file (default: stdout)")
+	audit := fs.Bool("audit", false, "compare the Fitbit logs with HealthPlanet instead of dumping them")
+	aggregate := fs.String("aggregate", "", "daily aggregation policy the readings were synced with, for --audit (all, first, last, min, mean, median; default: config or all)")
+	requests := addRequestFlags(fs)
+	setupLogger := addLogFlags(fs)
+	_ = fs.Parse(args)
+	setupLogger()
+
+	cfg := loadConfig()
+	ctx, cancel := requests.context(cfg)
+	defer cancel()
+	fitbitApi := newFitbitAPI(ctx, cfg, requests, nil)
+	setupFitbitUnits(ctx, cfg, fitbitApi)
+
+	fromTime, toTime, err := dateRange(*from, *to)
+	if err != nil {
+		fatal("invalid date range", "error", err)
+	}
+
+	logs, err := fitbitApi.ListBodyLogs(ctx, fromTime, toTime)
+	if err != nil {
+		fatalAPI("failed to list fitbit body logs", "fitbit", err)
+	}
+
+	// Fitbit returns whole days; drop logs outside of the exact range
+	inRange := logs[:0]
+	for _, l := range logs {
+		if !l.Time.Before(fromTime) && !l.Time.After(toTime) {
+			inRange = append(inRange, l)
+		}
+	}
+	logs = inRange
+
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fatal("failed to create output file", "path", *output, "error", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *audit {
+		healthPlanetAPI := newHealthPlanetAPI(cfg, requests, nil)
+
+		apiFrom, apiTo := apiDateRange(*from, *to)
+		scanData, err := healthPlanetAPI.AggregateInnerScanData(ctx, apiFrom, apiTo)
+		if err != nil {
+			fatalAPI("failed to aggregate inner scan data", "healthplanet", err)
+		}
+
+		// Compare with the entries the sync wrote, one per day unless the
+		// policy is all
+		report, err := htf.AuditDaily(scanData, logs, aggregationPolicy(cfg, *aggregate))
+		if err != nil {
+			fatal("failed to audit", "error", err)
+		}
+		if err := htf.WriteAudit(w, *format, report); err != nil {
+			fatal("failed to write audit", "error", err)
+		}
+
+		slog.Info("audited",
+			"missing_in_fitbit", len(report.MissingInFitbit),
+			"unknown_in_fitbit", len(report.UnknownInFitbit),
+			"mismatches", len(report.Mismatches))
+	} else {
+		if err := htf.WriteBodyLogs(w, *format, logs); err != nil {
+			fatal("failed to export", "error", err)
+		}
+
+		slog.Info("exported", "count", len(logs))
+	}
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	htf "healthplanet-to-fitbit"
 	"healthplanet-to-fitbit/config"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -18,10 +22,32 @@ func main() {
 	// Load environment variables
 	_ = godotenv.Load(".env")
 
-	// Load config
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "export":
+			runExport(args[1:])
+			return
+		case "fitbit-export":
+			runFitbitExport(args[1:])
+			return
+		case "report":
+			runReport(args[1:])
+			return
+		case "serve":
+			runServe(args[1:])
+			return
+		}
+	}
+
+	runSync(args)
+}
+
+// loadConfig loads the config file, falling back to env vars for empty fields.
+func loadConfig() *config.Config {
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
+		fatal("failed to load config", "error", err)
 	}
 
 	// Fallback to env vars if config is empty (for backward compatibility or initial setup)
@@ -41,137 +67,769 @@ func main() {
 		cfg.Fitbit.RefreshToken = os.Getenv("FITBIT_REFRESH_TOKEN")
 	}
 
-	// Initialize API clients
-	healthPlanetAPI := htf.HealthPlanetAPI{
-		AccessToken: cfg.HealthPlanet.AccessToken,
+	return cfg
+}
+
+// apiDateRange converts YYYY-MM-DD dates into the HealthPlanet API format.
+// An empty from defaults to 3 months ago, an empty to to today.
+func apiDateRange(from, to string) (string, string) {
+	return htf.HealthPlanetDateRange(from, to, time.Now())
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// Exit codes of a run that failed calling a provider, telling a scheduler
+// what to do about it.
+const (
+	exitAbort  = 1
+	exitRetry  = 75 // EX_TEMPFAIL: run again later
+	exitReauth = 77 // EX_NOPERM: authorize again with the gettoken command
+)
+
+// remedy decides from the type of err, returned by provider, whether to run
+// again later, authorize again or give up. It returns the remedy (retry,
+// reauth, abort), the exit code and a hint for the user.
+func remedy(provider string, err error) (string, int, string) {
+	gettoken := provider + "-gettoken"
+	var retrieveErr *oauth2.RetrieveError
+	switch {
+	case errors.Is(err, htf.ErrRateLimited):
+		if reset := htf.RateLimitReset(err); !reset.IsZero() {
+			return "retry", exitRetry, "rate limited until " + reset.Format(time.RFC3339)
+		}
+		return "retry", exitRetry, "rate limited, try again in an hour"
+	case errors.Is(err, htf.ErrTokenExpired):
+		return "reauth", exitReauth, "the access token has expired; run " + gettoken
+	case errors.Is(err, htf.ErrInsufficientScope):
+		return "reauth", exitReauth, "the token lacks a scope; run " + gettoken + " to grant it"
+	case errors.Is(err, htf.ErrUnauthorized):
+		return "reauth", exitReauth, "the token was rejected; run " + gettoken
+	case errors.As(err, &retrieveErr):
+		// The refresh token itself was refused
+		return "reauth", exitReauth, "failed to refresh the token; run " + gettoken
+	case errors.Is(err, htf.ErrBadRequest) && provider == "healthplanet":
+		// HealthPlanet answers 400 when rate limited (approx 60 req/hour)
+		return "retry", exitRetry, "HealthPlanet may be rate limiting; try again in an hour"
+	case errors.Is(err, htf.ErrUpstream), htf.IsTransient(err), errors.Is(err, context.DeadlineExceeded):
+		return "retry", exitRetry, "the provider is unavailable; try again later"
 	}
+	return "abort", exitAbort, ""
+}
+
+// fatalAPI logs msg for err, returned by provider, along with what to do
+// about it, and exits with the matching exit code.
+func fatalAPI(msg, provider string, err error) {
+	action, code, hint := remedy(provider, err)
+	slog.Error(msg, "provider", provider, "status_code", htf.StatusCode(err), "remedy", action, "hint", hint, "error", err)
+	os.Exit(code)
+}
+
+// addLogFlags registers --log-format and --log-level on fs and returns a
+// function that installs the configured logger as the default one.
+func addLogFlags(fs *flag.FlagSet) func() {
+	format := fs.String("log-format", htf.LogFormatText, "log format (text, json)")
+	level := fs.String("log-level", "info", "log level (debug, info, warn, error)")
+	return func() {
+		logger, err := htf.NewLogger(os.Stderr, *format, *level)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		slog.SetDefault(logger)
+	}
+}
+
+// requestFlags holds the flags controlling API requests: --timeout,
+// --call-timeout and --max-attempts.
+type requestFlags struct {
+	run      *time.Duration
+	call     *time.Duration
+	attempts *int
 
+	// retry is set from the flags and the config by context.
+	retry htf.RetryPolicy
+}
+
+// addRequestFlags registers --timeout, --call-timeout and --max-attempts on
+// fs.
+func addRequestFlags(fs *flag.FlagSet) *requestFlags {
+	return &requestFlags{
+		run:      fs.Duration("timeout", 10*time.Minute, "abort the whole run after this duration (0 disables)"),
+		call:     fs.Duration("call-timeout", 30*time.Second, "abort a single API call attempt after this duration (0 disables)"),
+		attempts: fs.Int("max-attempts", 0, fmt.Sprintf("attempts per API call on network errors, 5xx and 429 (1 disables retries; default: config or %d)", htf.DefaultRetryPolicy.MaxAttempts)),
+	}
+}
+
+// context returns the context of the run. It is cancelled on SIGINT or
+// SIGTERM, which stops in-flight requests, and when the run times out. Once
+// it is done, a second signal terminates the process immediately.
+func (t *requestFlags) context(cfg *config.Config) (context.Context, context.CancelFunc) {
+	t.retry = htf.DefaultRetryPolicy
+	if cfg.Retry.MaxAttempts > 0 {
+		t.retry.MaxAttempts = cfg.Retry.MaxAttempts
+	}
+	if *t.attempts > 0 {
+		t.retry.MaxAttempts = *t.attempts
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	cancel := context.CancelFunc(func() {})
+	if *t.run > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, *t.run, fmt.Errorf("run timed out after %s", *t.run))
+	}
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// Token refreshes use the client in the context
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, t.client(nil))
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// notifyTimeout bounds a notification when --call-timeout is disabled, since
+// notifications are sent even after the run is cancelled or timed out.
+const notifyTimeout = time.Minute
+
+// notifyContext returns the context of a notification about the run with
+// ctx. It is not cancelled with ctx, so that the run can be notified even if
+// it was interrupted, and is limited by --call-timeout, or notifyTimeout if
+// that is disabled.
+func (t *requestFlags) notifyContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := *t.call
+	if timeout <= 0 {
+		timeout = notifyTimeout
+	}
+	return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
+
+// client returns an HTTP client retrying failed reads and applying the call
+// timeout to each attempt. Every attempt is logged at debug level.
+func (t *requestFlags) client(transport http.RoundTripper) *http.Client {
+	return &http.Client{Transport: &htf.RetryTransport{
+		Base:           &htf.DebugTransport{Base: transport},
+		Policy:         t.retry,
+		AttemptTimeout: *t.call,
+	}}
+}
+
+// interrupted logs why ctx was cancelled and reports whether it was.
+func interrupted(ctx context.Context) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	slog.Warn("interrupted", "error", context.Cause(ctx))
+	return true
+}
+
+// newNotifier builds the notifiers configured in cfg.
+func newNotifier(cfg *config.Config) htf.Notifiers {
+	var notifiers htf.Notifiers
+	if cfg.Notify.Webhook.URL != "" {
+		notifiers = append(notifiers, &htf.WebhookNotifier{URL: cfg.Notify.Webhook.URL})
+	}
+	if cfg.Notify.Slack.URL != "" {
+		notifiers = append(notifiers, &htf.SlackNotifier{URL: cfg.Notify.Slack.URL})
+	}
+	if cfg.Notify.Discord.URL != "" {
+		notifiers = append(notifiers, &htf.DiscordNotifier{URL: cfg.Notify.Discord.URL})
+	}
+	if cfg.Notify.SMTP.Addr != "" {
+		notifiers = append(notifiers, &htf.SMTPNotifier{
+			Addr:     cfg.Notify.SMTP.Addr,
+			Username: cfg.Notify.SMTP.Username,
+			Password: cfg.Notify.SMTP.Password,
+			From:     cfg.Notify.SMTP.From,
+			To:       cfg.Notify.SMTP.To,
+		})
+	}
+	return notifiers
+}
+
+func openLedger() *htf.FileSink {
+	path, err := config.LedgerPath()
+	if err != nil {
+		fatal("failed to get ledger path", "error", err)
+	}
+	return &htf.FileSink{Path: path, Format: htf.FileFormatJSONLines}
+}
+
+// trendAlpha returns the configured smoothing factor of the weight trend.
+func trendAlpha(cfg *config.Config) float64 {
+	if cfg.Trend.Alpha == 0 {
+		return htf.DefaultTrendAlpha
+	}
+	return cfg.Trend.Alpha
+}
+
+func openRunLog() *htf.RunLog {
+	path, err := config.RunLogPath()
+	if err != nil {
+		fatal("failed to get run log path", "error", err)
+	}
+	return &htf.RunLog{Path: path}
+}
+
+// newFitbitAPI returns a Fitbit client sending its requests, including token
+// refreshes, through transport. A nil transport means the default one.
+func newFitbitAPI(ctx context.Context, cfg *config.Config, requests *requestFlags, transport http.RoundTripper) *htf.FitbitAPI {
 	fitbitToken := &oauth2.Token{
 		AccessToken:  cfg.Fitbit.AccessToken,
 		RefreshToken: cfg.Fitbit.RefreshToken,
+		Expiry:       cfg.Fitbit.Expiry,
 	}
-	fitbitApi := htf.NewFitbitAPI(cfg.Fitbit.ClientID, cfg.Fitbit.ClientSecret, fitbitToken)
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, requests.client(transport))
+	api := htf.NewFitbitAPI(ctx, cfg.Fitbit.ClientID, cfg.Fitbit.ClientSecret, fitbitToken, func(token *oauth2.Token) error {
+		return saveFitbitToken(cfg, token)
+	})
+	return api
+}
 
-	// Parse CLI flags
-	var from, to string
-	args := os.Args[1:]
-	for i := 0; i < len(args); i++ {
-		switch args[i] {
-		case "--from":
-			if i+1 < len(args) {
-				from = args[i+1]
-				i++
-			}
-		case "--to":
-			if i+1 < len(args) {
-				to = args[i+1]
-				i++
-			}
+// saveFitbitToken saves a refreshed Fitbit token to the config file. It runs
+// before the token is used, since the previous refresh token is spent.
+func saveFitbitToken(cfg *config.Config, token *oauth2.Token) error {
+	cfg.Fitbit.AccessToken = token.AccessToken
+	cfg.Fitbit.RefreshToken = token.RefreshToken
+	cfg.Fitbit.Expiry = token.Expiry
+	if err := config.SaveConfig(cfg); err != nil {
+		return err
+	}
+	slog.Info("token refreshed and saved to config", "provider", "fitbit")
+	return nil
+}
+
+func newHealthPlanetAPI(cfg *config.Config, requests *requestFlags, transport http.RoundTripper) *htf.HealthPlanetAPI {
+	return &htf.HealthPlanetAPI{
+		AccessToken: cfg.HealthPlanet.AccessToken,
+		Client:      requests.client(transport),
+	}
+}
+
+// setupFitbitUnits sets the weight unit and rounding of fitbitApi from the
+// config, reading the unit from the Fitbit profile unless it is configured.
+func setupFitbitUnits(ctx context.Context, cfg *config.Config, fitbitApi *htf.FitbitAPI) {
+	r := rounding(cfg)
+	fitbitApi.Rounding = &r
+
+	if unit := cfg.Units.Weight; unit != "" && unit != "auto" {
+		if _, err := htf.ParseWeightUnit(unit); err != nil {
+			fatal("invalid weight unit", "error", err)
 		}
+		fitbitApi.WeightUnit = unit
+		return
 	}
 
-	// Load cache
-	cacheData, err := config.LoadCache()
+	profile, err := fitbitApi.GetProfile(ctx)
 	if err != nil {
-		log.Printf("failed to load cache: %v", err)
+		// Tokens issued before the profile scope was requested cannot read it
+		slog.Warn("failed to detect weight unit, assuming kg", "provider", "fitbit", "status_code", htf.StatusCode(err), "error", err)
+		fitbitApi.WeightUnit = htf.WeightUnitKg
+		return
 	}
+	fitbitApi.WeightUnit = profile.PreferredWeightUnit()
+	slog.Debug("detected weight unit", "provider", "fitbit", "unit", fitbitApi.WeightUnit)
+}
 
-	// Initialize Context
-	ctx := context.Background()
+// aggregationPolicy returns the daily aggregation policy of the --aggregate
+// flag, or else of the config.
+func aggregationPolicy(cfg *config.Config, flagValue string) string {
+	policy := cfg.Aggregation.Policy
+	if flagValue != "" {
+		policy = flagValue
+	}
+	policy, err := htf.ParseAggregatePolicy(policy)
+	if err != nil {
+		fatal("invalid aggregation policy", "error", err)
+	}
+	return policy
+}
 
-	// Get data from HealthPlanet
-	// Format dates for API (YYYYMMDDHHMM)
-	var apiFrom, apiTo string
+// rounding returns the configured rounding policy.
+func rounding(cfg *config.Config) htf.Rounding {
+	r := htf.DefaultRounding
+	if cfg.Units.Rounding != "" {
+		r.Mode = cfg.Units.Rounding
+	}
+	if cfg.Units.Decimals != nil {
+		r.Decimals = *cfg.Units.Decimals
+	}
+	if err := r.Validate(); err != nil {
+		fatal("invalid rounding", "error", err)
+	}
+	return r
+}
+
+// weightUnitFlag returns the weight unit for exports and reports: the flag
+// if given, else the configured unit if it is fixed, else kg.
+func weightUnitFlag(value string, cfg *config.Config) string {
+	if value != "" {
+		unit, err := htf.ParseWeightUnit(value)
+		if err != nil {
+			fatal("invalid weight unit", "error", err)
+		}
+		return unit
+	}
+	if unit, err := htf.ParseWeightUnit(cfg.Units.Weight); err == nil {
+		return unit
+	}
+	return htf.WeightUnitKg
+}
+
+// Watermark profiles of the sync.
+const (
+	watermarkInnerScan = "innerscan"
+	watermarkPedometer = "pedometer"
+)
+
+// startDate returns the start date (YYYY-MM-DD) of a sync of profile: from
+// if given, else the day of its watermark, else empty for the default range.
+func startDate(from string, watermarks *config.Watermarks, profile string) string {
 	if from != "" {
-		apiFrom = from + "000000"
-		apiFrom = strings.ReplaceAll(apiFrom, "-", "")
-	} else {
-		// Default to 3 months ago
-		apiFrom = time.Now().AddDate(0, -3, 0).Format("20060102") + "000000"
+		return from
+	}
+	if w, ok := watermarks.Get(profile); ok && !w.SyncedThrough.IsZero() {
+		// Start at the beginning of the day, which may have later readings
+		jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+		return w.SyncedThrough.In(jst).Format("2006-01-02")
 	}
+	return ""
+}
 
-	if to != "" {
-		apiTo = to + "235959"
-		apiTo = strings.ReplaceAll(apiTo, "-", "")
-	} else {
-		// Default to now
-		apiTo = time.Now().Format("20060102") + "235959"
+// advanceWatermark moves the watermark of profile to through, unless the
+// sync started after the watermark: the readings in between were not synced.
+func advanceWatermark(watermarks *config.Watermarks, profile, start string, through time.Time) {
+	if through.IsZero() {
+		return
 	}
+	if w, ok := watermarks.Get(profile); ok && start != "" {
+		jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+		if start > w.SyncedThrough.In(jst).Format("2006-01-02") {
+			slog.Info("watermark not advanced, the range starts after it", "profile", profile, "synced_through", w.SyncedThrough, "from", start)
+			return
+		}
+	}
+	watermarks.Advance(profile, through)
+}
+
+// registrationOverlap is how far before the registration watermark a
+// registration mode sync starts, in case the HealthPlanet clock is behind.
+// The readings fetched twice are skipped by the cache.
+const registrationOverlap = time.Hour
+
+// registrationRange returns the range (YYYYMMDDHHMMSS) of a registration
+// mode sync of profile: from and to if given, else from the registration
+// watermark if the last run was in registration mode, else the default range.
+func registrationRange(from, to string, watermarks *config.Watermarks, profile string) (string, string) {
+	apiFrom, apiTo := apiDateRange(from, to)
+	if from != "" {
+		return apiFrom, apiTo
+	}
+	if w, ok := watermarks.Get(profile); ok && w.Mode == htf.DateModeRegistration && !w.RegisteredThrough.IsZero() {
+		jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+		apiFrom = w.RegisteredThrough.Add(-registrationOverlap).In(jst).Format("20060102150405")
+		slog.Info("starting from registration watermark", "profile", profile, "from", apiFrom)
+	}
+	return apiFrom, apiTo
+}
 
-	scanData, err := healthPlanetAPI.AggregateInnerScanData(ctx, apiFrom, apiTo)
+// dateRange returns the range covered by apiDateRange as times.
+func dateRange(from, to string) (time.Time, time.Time, error) {
+	apiFrom, apiTo := apiDateRange(from, to)
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+
+	fromTime, err := time.ParseInLocation("20060102150405", apiFrom, jst)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	toTime, err := time.ParseInLocation("20060102150405", apiTo, jst)
 	if err != nil {
-		log.Fatalf("failed to aggregate inner scan data: %+v", err)
+		return time.Time{}, time.Time{}, err
 	}
 
-	// Save data to Fitbit
-	for t, data := range scanData {
-		// Convert to JST for logging and cache key
-		jst := time.FixedZone("Asia/Tokyo", 9*60*60)
-		tJST := t.In(jst)
-		cacheKey := tJST.Format("2006-01-02 15:04:05")
+	return fromTime.UTC(), toTime.UTC(), nil
+}
 
-		if cacheData.Has(cacheKey) {
-			log.Printf("%s: skipped from cache", tJST)
-			continue
+func runSync(args []string) {
+	// Parse CLI flags
+	fs := flag.NewFlagSet("healthplanet-to-fitbit", flag.ExitOnError)
+	from := fs.String("from", "", "start date (YYYY-MM-DD, default: 3 months ago)")
+	to := fs.String("to", "", "end date (YYYY-MM-DD, default: today)")
+	importFile := fs.String("import", "", "CSV file downloaded from the HealthPlanet website to import instead of calling the API")
+	dateModeFlag := fs.String("date-mode", "", "select readings by measurement or registration date (measurement, registration; default: config or measurement)")
+	newestFirst := fs.Bool("newest-first", false, "write the newest readings first (for backfilling long ranges)")
+	aggregate := fs.String("aggregate", "", "daily aggregation policy for Fitbit (all, first, last, min, mean, median; default: config or all)")
+	metricsFile := fs.String("metrics-file", "", "write Prometheus metrics to this file (node_exporter textfile collector) after the run")
+	requests := addRequestFlags(fs)
+	setupLogger := addLogFlags(fs)
+	_ = fs.Parse(args)
+	setupLogger()
+
+	cfg := loadConfig()
+	ctx, cancel := requests.context(cfg)
+	defer cancel()
+
+	metrics := htf.NewMetrics()
+	writeMetrics := func() {
+		if *metricsFile == "" {
+			return
+		}
+		if err := metrics.WriteTextfile(*metricsFile); err != nil {
+			slog.Error("failed to write metrics", "path", *metricsFile, "error", err)
 		}
+	}
 
-		weightLog, err := fitbitApi.GetBodyWeightLog(t)
-		if err != nil {
-			log.Printf("failed to get weight log from fitbit: %+v", err)
-			break
+	// Initialize API clients
+	healthPlanetAPI := newHealthPlanetAPI(cfg, requests, metrics.Transport("healthplanet", nil))
+
+	fitbitApi := newFitbitAPI(ctx, cfg, requests, metrics.Transport("fitbit", nil))
+	setupFitbitUnits(ctx, cfg, fitbitApi)
+
+	// Additional destinations besides Fitbit. The ledger keeps a local copy
+	// of the readings and their trend for reports.
+	ledger := openLedger()
+	var sinks []htf.Sink
+	if cfg.Sinks.File.Path != "" {
+		sinks = append(sinks, &htf.FileSink{
+			Path:   cfg.Sinks.File.Path,
+			Format: cfg.Sinks.File.Format,
+		})
+	}
+	if cfg.Sinks.InfluxDB.URL != "" {
+		sinks = append(sinks, &htf.InfluxDBSink{
+			URL:         cfg.Sinks.InfluxDB.URL,
+			Database:    cfg.Sinks.InfluxDB.Database,
+			Measurement: cfg.Sinks.InfluxDB.Measurement,
+			Token:       cfg.Sinks.InfluxDB.Token,
+		})
+	}
+
+	// Load cache
+	cacheData, err := config.LoadCache()
+	if err != nil {
+		// Saving an empty cache over it would lose what was synced
+		fatal("failed to load cache", "error", err)
+	}
+	metrics.SetLastSuccess(cacheData.LastSuccessfulSync)
+
+	// Load quarantine
+	quarantine, err := config.LoadQuarantine()
+	if err != nil {
+		fatal("failed to load quarantine", "error", err)
+	}
+
+	// Load watermarks
+	watermarks, err := config.LoadWatermarks()
+	if err != nil {
+		fatal("failed to load watermarks", "error", err)
+	}
+
+	// saveState compacts the cache journal into the cache and saves the
+	// quarantine and watermarks. Created entries are journaled as they are written, so this
+	// only has to run once the run ends or is interrupted.
+	saveState := func() {
+		if err := config.SaveCache(cacheData); err != nil {
+			slog.Error("failed to save cache", "error", err)
 		}
+		if err := config.SaveQuarantine(quarantine); err != nil {
+			slog.Error("failed to save quarantine", "error", err)
+		}
+		if err := config.SaveWatermarks(watermarks); err != nil {
+			slog.Error("failed to save watermarks", "error", err)
+		}
+	}
 
-		if len(weightLog.Weight) > 0 {
-			log.Printf("%s: record is found", tJST)
-			cacheData.Add(cacheKey)
-			continue
+	if !cfg.HealthPlanet.Expiry.IsZero() {
+		metrics.SetTokenExpiry("healthplanet", cfg.HealthPlanet.Expiry)
+	}
+
+	notifier := newNotifier(cfg)
+	notify := func(s htf.SyncSummary) {
+		if len(notifier) == 0 {
+			return
+		}
+		if s.Time.IsZero() {
+			s.Time = time.Now()
 		}
+		notifyCtx, cancel := requests.notifyContext(ctx)
+		defer cancel()
+		if err := notifier.Notify(notifyCtx, s); err != nil {
+			slog.Error("failed to notify", "event", s.Event, "error", err)
+		}
+	}
 
-		if data.Weight != nil {
-			if err := fitbitApi.CreateWeightLog(*data.Weight, t); err != nil {
-				log.Printf("failed to create weight log: time: %s, err: %+v", tJST, err)
-				break
+	// finish records the outcome of the run and notifies about failures and
+	// new readings.
+	runLog := openRunLog()
+	finish := func(s htf.SyncSummary) {
+		s.Time = time.Now()
+		if err := runLog.Append(s); err != nil {
+			slog.Error("failed to record run", "path", runLog.Path, "error", err)
+		}
+		if s.Event == htf.NotifyEventFailure || s.Created > 0 {
+			notify(s)
+		}
+	}
+
+	// Warn about tokens that cannot be refreshed automatically
+	warningDays := cfg.Notify.TokenExpiryWarningDays
+	if warningDays == 0 {
+		warningDays = 7
+	}
+	if expiry := cfg.HealthPlanet.Expiry; !expiry.IsZero() && time.Until(expiry) < time.Duration(warningDays)*24*time.Hour {
+		slog.Warn("token expires soon", "provider", "healthplanet", "expiry", expiry)
+		notify(htf.SyncSummary{Event: htf.NotifyEventTokenExpiring, Provider: "healthplanet", TokenExpiry: &expiry})
+	}
+
+	// Reduce to one entry per day for Fitbit if configured
+	policy := aggregationPolicy(cfg, *aggregate)
+
+	dateMode := cfg.HealthPlanet.DateMode
+	if *dateModeFlag != "" {
+		dateMode = *dateModeFlag
+	}
+	dateMode, err = htf.ParseDateMode(dateMode)
+	if err != nil {
+		fatal("invalid date mode", "error", err)
+	}
+	healthPlanetAPI.DateMode = dateMode
+
+	var source htf.Source
+	sourceProvider := "healthplanet"
+	runStart := time.Now()
+	scanFrom := startDate(*from, watermarks, watermarkInnerScan)
+	if *importFile != "" {
+		// Get data from HealthPlanet CSV download
+		sourceProvider = ""
+		source = htf.SourceFunc(func(ctx context.Context) (htf.AggregatedInnerScanDataMap, error) {
+			data, err := importCSV(*importFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to import csv %s: %w", *importFile, err)
+			}
+			slog.Info("imported csv", "path", *importFile, "count", len(data))
+			return data, nil
+		})
+	} else {
+		// Get data from HealthPlanet, by default from the watermark on
+		var apiFrom, apiTo string
+		if dateMode == htf.DateModeRegistration {
+			apiFrom, apiTo = registrationRange(*from, *to, watermarks, watermarkInnerScan)
+		} else {
+			if scanFrom != *from {
+				slog.Info("starting from watermark", "profile", watermarkInnerScan, "from", scanFrom)
+			}
+			apiFrom, apiTo = apiDateRange(scanFrom, *to)
+		}
+		source = &htf.HealthPlanetSource{API: healthPlanetAPI, From: apiFrom, To: apiTo, WholeDays: policy != htf.AggregatePolicyAll}
+	}
+
+	// Save data to Fitbit
+	quarantined := 0
+	syncer := &htf.Syncer{
+		Source:      source,
+		Sink:        fitbitApi,
+		Cache:       cacheData,
+		Quarantine:  quarantine,
+		Policy:      policy,
+		NewestFirst: *newestFirst,
+		Rollback:    cfg.Fitbit.RollbackPartial,
+		Retry:       &requests.retry,
+		OnFetched: func(data htf.AggregatedInnerScanDataMap) {
+			metrics.AddFetched(len(data))
+			data.ApplyDerived()
+
+			// Correct the Fitbit profile height, which Fitbit uses for its own BMI
+			if cfg.Fitbit.UpdateHeight {
+				if height, ok := data.Profile().HeightCm(); ok {
+					updated, err := fitbitApi.SyncHeight(ctx, height)
+					if err != nil {
+						slog.Error("failed to update height", "provider", "fitbit", "status_code", htf.StatusCode(err), "error", err)
+					} else if updated {
+						slog.Info("updated height", "provider", "fitbit", "height", height)
+					}
+				}
+			}
+		},
+		OnCreated: func(htf.ReadingResult) {
+			metrics.IncCreated()
+		},
+		OnSkipped: func(r htf.ReadingResult) {
+			switch r.Outcome {
+			case htf.OutcomeSkippedCache:
+				metrics.IncSkippedCache()
+			case htf.OutcomeSkippedExisting:
+				metrics.IncSkippedExisting()
+			}
+		},
+		OnError: func(r htf.ReadingResult) {
+			if r.Outcome != htf.OutcomeRejected {
+				return
+			}
+			// Fitbit rejected this reading; quarantine it
+			quarantined++
+			quarantine.Add(r.Key, config.QuarantineEntry{
+				Time:          r.Time,
+				Weight:        r.Data.Weight,
+				Fat:           r.Data.Fat,
+				Reason:        r.Err.Error(),
+				StatusCode:    htf.StatusCode(r.Err),
+				QuarantinedAt: time.Now(),
+			})
+			if err := config.SaveQuarantine(quarantine); err != nil {
+				slog.Error("failed to save quarantine", "error", err)
+			}
+		},
+	}
+
+	result, syncErr := syncer.Run(ctx)
+	if *importFile == "" {
+		if dateMode == htf.DateModeRegistration {
+			// Everything registered before the run started is synced
+			if result.Complete() && *from == "" && *to == "" {
+				watermarks.AdvanceRegistered(watermarkInnerScan, runStart)
 			}
+		} else {
+			advanceWatermark(watermarks, watermarkInnerScan, scanFrom, result.SyncedThrough)
 		}
+		watermarks.SetMode(watermarkInnerScan, dateMode)
+	}
+	if interrupted(ctx) {
+		// Flush before anything else so a second signal loses nothing
+		saveState()
+	}
+	if result.Data == nil {
+		writeMetrics()
+		finish(htf.SyncSummary{Event: htf.NotifyEventFailure, Provider: sourceProvider, StatusCode: htf.StatusCode(syncErr), Error: syncErr.Error()})
+		fatalAPI("failed to fetch readings", sourceProvider, syncErr)
+	}
+	scanData := result.Data
+	created := result.Created
+	syncProvider := ""
+	if syncErr != nil {
+		syncProvider = "fitbit"
+	}
 
-		if data.Fat != nil {
-			if err := fitbitApi.CreateBodyFatLog(*data.Fat, t); err != nil {
-				log.Printf("failed to create fat log: time: %s, err: %+v", tJST, err)
-				break
+	// Save steps to Fitbit
+	if cfg.Pedometer.Enabled && *importFile == "" && syncErr == nil {
+		stepsFrom := startDate(*from, watermarks, watermarkPedometer)
+		apiFrom, apiTo := apiDateRange(stepsFrom, *to)
+		stepsSyncer := &htf.StepsSyncer{
+			Source:      &htf.HealthPlanetStepsSource{API: healthPlanetAPI, From: apiFrom, To: apiTo},
+			Sink:        fitbitApi,
+			ActivityID:  cfg.Pedometer.ActivityID,
+			Cache:       cacheData,
+			NewestFirst: *newestFirst,
+			Retry:       &requests.retry,
+			OnFetched: func(data htf.StepsDataMap) {
+				metrics.AddFetched(len(data))
+			},
+			OnCreated: func(htf.ReadingResult) {
+				metrics.IncCreated()
+			},
+			OnSkipped: func(r htf.ReadingResult) {
+				switch r.Outcome {
+				case htf.OutcomeSkippedCache:
+					metrics.IncSkippedCache()
+				case htf.OutcomeSkippedExisting:
+					metrics.IncSkippedExisting()
+				}
+			},
+		}
+		steps, err := stepsSyncer.Run(ctx)
+		created += steps.Created
+		advanceWatermark(watermarks, watermarkPedometer, stepsFrom, steps.SyncedThrough)
+		if err != nil {
+			syncErr, syncProvider = err, "fitbit"
+			if steps.Data == nil {
+				slog.Error("failed to aggregate steps", "provider", "healthplanet", "status_code", htf.StatusCode(err), "error", err)
+				syncProvider = "healthplanet"
 			}
 		}
+	}
 
-		printFloat := func(f *float64) string {
-			if f == nil {
-				return "nil"
+	// Save data to additional sinks
+	syncSink := func(sink htf.Sink) {
+		sinkCreated, err := htf.SyncSink(ctx, sink, scanData)
+		if err != nil {
+			slog.Error("failed to sync", "provider", sink.Name(), "error", err)
+			if syncErr == nil {
+				syncErr, syncProvider = err, sink.Name()
+			}
+		}
+		slog.Info("synced", "provider", sink.Name(), "action", "created", "count", sinkCreated)
+	}
+	syncSink(ledger)
+	trend, trendErr := htf.UpdateLedgerTrend(ledger, trendAlpha(cfg))
+	if trendErr != nil {
+		slog.Error("failed to update trend", "provider", ledger.Name(), "error", trendErr)
+	} else if cfg.Trend.PushToSinks {
+		scanData.ApplyTrend(trend)
+	}
+	for _, sink := range sinks {
+		syncSink(sink)
+		if trendErr == nil && cfg.Trend.PushToSinks {
+			// Late readings change the trend of rows the sink already has
+			updated, err := htf.PushTrend(ctx, sink, trend)
+			if err != nil {
+				slog.Error("failed to update trend", "provider", sink.Name(), "error", err)
+			} else if updated > 0 {
+				slog.Info("updated trend", "provider", sink.Name(), "count", updated)
 			}
-			return fmt.Sprintf("%.2f", *f)
 		}
+	}
 
-		log.Printf("%s: saved, weight: %s, fat: %s", tJST, printFloat(data.Weight), printFloat(data.Fat))
-		cacheData.Add(cacheKey)
+	summary := htf.SyncSummary{Event: htf.NotifyEventSuccess, Fetched: len(scanData), Created: created}
+	if syncErr != nil {
+		summary.Event = htf.NotifyEventFailure
+		summary.Provider = syncProvider
+		summary.StatusCode = htf.StatusCode(syncErr)
+		summary.Error = syncErr.Error()
+	} else {
+		cacheData.LastSuccessfulSync = time.Now()
+		metrics.SetLastSuccess(cacheData.LastSuccessfulSync)
 	}
+	finish(summary)
 
 	// Save cache
-	if err := config.SaveCache(cacheData); err != nil {
-		log.Printf("failed to save cache: %v", err)
+	saveState()
+
+	if !cfg.Fitbit.Expiry.IsZero() {
+		metrics.SetTokenExpiry("fitbit", cfg.Fitbit.Expiry)
+	}
+
+	writeMetrics()
+
+	exitSync(syncProvider, syncErr, quarantined)
+	slog.Info("done")
+}
+
+// exitSync exits with the status of a sync run that failed with syncErr,
+// returned by provider, or that quarantined readings Fitbit rejected. The
+// readings after a rejected one are still synced, but the run fails once so
+// that a scheduler notices; later runs skip the quarantined readings until
+// they are released or dismissed. It returns if the run succeeded.
+func exitSync(provider string, syncErr error, quarantined int) {
+	if syncErr != nil {
+		fatalAPI("failed to sync", provider, syncErr)
+	}
+	if quarantined > 0 {
+		slog.Error("rejected readings were quarantined", "provider", "fitbit", "count", quarantined, "remedy", "abort", "hint", "release or dismiss them on the quarantine page of the serve command")
+		os.Exit(exitAbort)
 	}
+}
 
-	// Check and save token if refreshed
-	newToken, err := fitbitApi.TokenSource.Token()
+func importCSV(path string) (htf.AggregatedInnerScanDataMap, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		log.Printf("failed to get current token: %v", err)
-	} else {
-		if newToken.AccessToken != cfg.Fitbit.AccessToken || newToken.RefreshToken != cfg.Fitbit.RefreshToken {
-			cfg.Fitbit.AccessToken = newToken.AccessToken
-			cfg.Fitbit.RefreshToken = newToken.RefreshToken
-			if err := config.SaveConfig(cfg); err != nil {
-				log.Printf("failed to save config: %v", err)
-			} else {
-				log.Printf("token refreshed and saved to config")
-			}
-		}
+		return nil, err
 	}
+	defer f.Close()
 
-	log.Printf("done")
+	return htf.ParseHealthPlanetCSV(f)
 }
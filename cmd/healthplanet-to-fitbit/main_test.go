@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	htf "healthplanet-to-fitbit"
+)
+
+func TestRequestFlags_NotifyContext(t *testing.T) {
+	received := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+	}))
+	defer srv.Close()
+
+	for _, args := range [][]string{{"--call-timeout", "0"}, {"--call-timeout", "5s"}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		requests := addRequestFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+
+		// The run was interrupted before notifying
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		notifyCtx, cancelNotify := requests.notifyContext(ctx)
+		if err := notifyCtx.Err(); err != nil {
+			t.Errorf("%v: notification context is done: %v", args, err)
+		}
+		if deadline, ok := notifyCtx.Deadline(); !ok || time.Until(deadline) > notifyTimeout {
+			t.Errorf("%v: deadline = %v, %v, want a bounded one", args, deadline, ok)
+		}
+
+		notifier := htf.Notifiers{&htf.WebhookNotifier{URL: srv.URL}}
+		if err := notifier.Notify(notifyCtx, htf.SyncSummary{Event: htf.NotifyEventFailure, Time: time.Now()}); err != nil {
+			t.Errorf("%v: Notify() error = %v", args, err)
+		}
+		cancelNotify()
+	}
+	if received != 2 {
+		t.Errorf("received %d notifications, want 2", received)
+	}
+}
+
+func TestExitSync(t *testing.T) {
+	tests := []struct {
+		name        string
+		provider    string
+		err         error
+		quarantined int
+		want        int
+	}{
+		{"success", "", nil, 0, 0},
+		// Rejected readings no longer stop the run, but still fail it
+		{"quarantined", "", nil, 2, exitAbort},
+		{"bad request", "fitbit", &htf.APIError{Provider: "fitbit", StatusCode: http.StatusBadRequest}, 0, exitAbort},
+		{"rate limited", "fitbit", &htf.APIError{Provider: "fitbit", StatusCode: http.StatusTooManyRequests}, 1, exitRetry},
+		{"expired token", "fitbit", &htf.APIError{Provider: "fitbit", StatusCode: http.StatusUnauthorized}, 0, exitReauth},
+	}
+	for i, tt := range tests {
+		if os.Getenv("HTF_TEST_EXIT_SYNC") == strconv.Itoa(i) {
+			exitSync(tt.provider, tt.err, tt.quarantined)
+			os.Exit(0)
+		}
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestExitSync$")
+			cmd.Env = append(os.Environ(), "HTF_TEST_EXIT_SYNC="+strconv.Itoa(i))
+			err := cmd.Run()
+			code := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.want {
+				t.Errorf("exit status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
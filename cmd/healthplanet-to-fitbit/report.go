@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	htf "healthplanet-to-fitbit"
+)
+
+// runReport writes a progress report built from the local ledger.
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	period := fs.String("period", htf.ReportPeriodWeek, "report period (week, month)")
+	date := fs.String("date", "", "any date in the period (YYYY-MM-DD, default: today)")
+	format := fs.String("format", htf.ReportFormatMarkdown, "output format (markdown, html)")
+	output := fs.String("output", "", "output file (default: stdout)")
+	unit := fs.String("unit", "", "weight unit (kg, lb; default: config or kg)")
+	setupLogger := addLogFlags(fs)
+	_ = fs.Parse(args)
+	setupLogger()
+
+	weightUnit := weightUnitFlag(*unit, loadConfig())
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	day := time.Now()
+	if *date != "" {
+		var err error
+		day, err = time.ParseInLocation("2006-01-02", *date, jst)
+		if err != nil {
+			fatal("invalid date", "date", *date, "error", err)
+		}
+	}
+
+	from, to, err := htf.ReportPeriodRange(*period, day)
+	if err != nil {
+		fatal("invalid period", "error", err)
+	}
+	prevFrom, _, err := htf.ReportPeriodRange(*period, from.Add(-time.Second))
+	if err != nil {
+		fatal("invalid period", "error", err)
+	}
+
+	ledger := openLedger()
+	ctx := context.Background()
+
+	current, err := ledger.ListMeasurements(ctx, from, to.Add(-time.Second))
+	if err != nil {
+		fatal("failed to read ledger", "path", ledger.Path, "error", err)
+	}
+	previous, err := ledger.ListMeasurements(ctx, prevFrom, from.Add(-time.Second))
+	if err != nil {
+		fatal("failed to read ledger", "path", ledger.Path, "error", err)
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fatal("failed to create output file", "path", *output, "error", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	report := htf.BuildReport(*period, from, to, current, previous).InUnit(weightUnit)
+	if err := htf.WriteReport(w, *format, report); err != nil {
+		fatal("failed to write report", "error", err)
+	}
+
+	slog.Info("reported", "period", *period, "from", from, "to", to, "count", len(current))
+}
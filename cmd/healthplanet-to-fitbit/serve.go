@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"flag"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	htf "healthplanet-to-fitbit"
+	"healthplanet-to-fitbit/config"
+)
+
+//go:embed web
+var webFS embed.FS
+
+var webTemplates = template.Must(template.ParseFS(webFS, "web/*.html"))
+
+type dashboard struct {
+	days int
+
+	mu          sync.Mutex
+	syncRunning bool
+	authFlow    *htf.FitbitAuthFlow
+}
+
+// runServe starts the local web dashboard.
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	// The Fitbit redirect URL is http://localhost:8080/callback
+	addr := flags.String("addr", "localhost:8080", "listen address")
+	days := flags.Int("days", 90, "number of days shown in the charts")
+	setupLogger := addLogFlags(flags)
+	_ = flags.Parse(args)
+	setupLogger()
+
+	d := &dashboard{days: *days}
+
+	static, err := fs.Sub(webFS, "web")
+	if err != nil {
+		fatal("failed to load assets", "error", err)
+	}
+
+	server := &http.Server{Addr: *addr, Handler: d.routes(static)}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("serving dashboard", "url", "http://"+*addr)
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			fatal("failed to start server", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		fatal("failed to shutdown server", "error", err)
+	}
+}
+
+func (d *dashboard) routes(static fs.FS) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.FS(static))))
+	mux.HandleFunc("GET /{$}", d.handleIndex)
+	mux.HandleFunc("POST /sync", sameOrigin(d.handleSync))
+	mux.HandleFunc("GET /quarantine", d.handleQuarantine)
+	mux.HandleFunc("POST /quarantine/release", sameOrigin(d.handleQuarantineRelease))
+	mux.HandleFunc("POST /quarantine/dismiss", sameOrigin(d.handleQuarantineDismiss))
+	mux.HandleFunc("GET /fitbit/authorize", d.handleAuthorize)
+	mux.HandleFunc("GET /callback", d.handleCallback)
+	return mux
+}
+
+// sameOrigin refuses requests that a page of another site made the browser
+// send, so that it cannot start a sync or change the quarantine. Browsers
+// send Sec-Fetch-Site or Origin with every POST; requests without either
+// come from other clients, such as curl, and are allowed.
+func sameOrigin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isSameOrigin(r) {
+			slog.Warn("refused cross-origin request", "method", r.Method, "path", r.URL.Path, "origin", r.Header.Get("Origin"))
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func isSameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+	default:
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func (d *dashboard) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := webTemplates.ExecuteTemplate(w, name, data); err != nil {
+		slog.Error("failed to render template", "template", name, "error", err)
+	}
+}
+
+func (d *dashboard) redirect(w http.ResponseWriter, r *http.Request, path, msg string) {
+	http.Redirect(w, r, path+"?msg="+url.QueryEscape(msg), http.StatusSeeOther)
+}
+
+func (d *dashboard) isSyncRunning() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.syncRunning
+}
+
+func (d *dashboard) handleIndex(w http.ResponseWriter, r *http.Request) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -d.days)
+
+	ledger := openLedger()
+	ms, err := ledger.ListMeasurements(r.Context(), from, to)
+	if err != nil {
+		slog.Error("failed to read ledger", "path", ledger.Path, "error", err)
+		http.Error(w, "failed to read ledger", http.StatusInternalServerError)
+		return
+	}
+
+	report := htf.BuildReport("", from, to, ms, nil)
+	var charts []template.HTML
+	for _, m := range report.Metrics[:2] { // weight and fat %
+		charts = append(charts, template.HTML(htf.SVGChart(m.Name, m.Unit, m.Points, from, to)))
+	}
+
+	runs, err := openRunLog().Recent(20)
+	if err != nil {
+		slog.Error("failed to read run log", "error", err)
+	}
+
+	quarantine, err := config.LoadQuarantine()
+	if err != nil {
+		slog.Error("failed to load quarantine", "error", err)
+		http.Error(w, "failed to load quarantine", http.StatusInternalServerError)
+		return
+	}
+
+	d.render(w, "index.html", map[string]interface{}{
+		"Message":         r.URL.Query().Get("msg"),
+		"Days":            d.days,
+		"Charts":          charts,
+		"Runs":            runs,
+		"QuarantineCount": len(quarantine.Entries),
+		"SyncRunning":     d.isSyncRunning(),
+	})
+}
+
+// handleSync runs a sync in a child process of this binary.
+func (d *dashboard) handleSync(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	if d.syncRunning {
+		d.mu.Unlock()
+		d.redirect(w, r, "/", "A sync is already running.")
+		return
+	}
+	d.syncRunning = true
+	d.mu.Unlock()
+
+	exe, err := os.Executable()
+	if err != nil {
+		d.mu.Lock()
+		d.syncRunning = false
+		d.mu.Unlock()
+		slog.Error("failed to find executable", "error", err)
+		d.redirect(w, r, "/", "Failed to start the sync.")
+		return
+	}
+
+	go func() {
+		defer func() {
+			d.mu.Lock()
+			d.syncRunning = false
+			d.mu.Unlock()
+		}()
+
+		cmd := exec.Command(exe)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		slog.Info("sync started")
+		if err := cmd.Run(); err != nil {
+			slog.Error("sync failed", "error", err)
+			return
+		}
+		slog.Info("sync finished")
+	}()
+
+	d.redirect(w, r, "/", "Sync started. Reload the page to see the result.")
+}
+
+type quarantineRow struct {
+	Key string
+	config.QuarantineEntry
+}
+
+func (d *dashboard) handleQuarantine(w http.ResponseWriter, r *http.Request) {
+	quarantine, err := config.LoadQuarantine()
+	if err != nil {
+		slog.Error("failed to load quarantine", "error", err)
+		http.Error(w, "failed to load quarantine", http.StatusInternalServerError)
+		return
+	}
+
+	var rows []quarantineRow
+	for _, k := range quarantine.Keys() {
+		rows = append(rows, quarantineRow{Key: k, QuarantineEntry: quarantine.Entries[k]})
+	}
+
+	d.render(w, "quarantine.html", map[string]interface{}{
+		"Message": r.URL.Query().Get("msg"),
+		"Entries": rows,
+	})
+}
+
+// updateQuarantine removes key from the quarantine. If dismiss is set, the
+// reading is also marked as processed so the sync never retries it.
+func (d *dashboard) updateQuarantine(key string, dismiss bool) error {
+	if d.isSyncRunning() {
+		return errors.New("a sync is running, try again later")
+	}
+
+	quarantine, err := config.LoadQuarantine()
+	if err != nil {
+		return err
+	}
+	if !quarantine.Has(key) {
+		return errors.New("reading is not quarantined")
+	}
+
+	if dismiss {
+		cacheData, err := config.LoadCache()
+		if err != nil {
+			return err
+		}
+		cacheData.Add(key)
+		if err := config.SaveCache(cacheData); err != nil {
+			return err
+		}
+	}
+
+	quarantine.Remove(key)
+	return config.SaveQuarantine(quarantine)
+}
+
+func (d *dashboard) handleQuarantineRelease(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	if err := d.updateQuarantine(key, false); err != nil {
+		d.redirect(w, r, "/quarantine", "Failed to release "+key+": "+err.Error())
+		return
+	}
+	slog.Info("released quarantined reading", "timestamp", key, "action", "release")
+	d.redirect(w, r, "/quarantine", "Released "+key+". It will be retried on the next sync.")
+}
+
+func (d *dashboard) handleQuarantineDismiss(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	if err := d.updateQuarantine(key, true); err != nil {
+		d.redirect(w, r, "/quarantine", "Failed to dismiss "+key+": "+err.Error())
+		return
+	}
+	slog.Info("dismissed quarantined reading", "timestamp", key, "action", "dismiss")
+	d.redirect(w, r, "/quarantine", "Dismissed "+key+".")
+}
+
+func (d *dashboard) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	cfg := loadConfig()
+	if cfg.Fitbit.ClientID == "" || cfg.Fitbit.ClientSecret == "" {
+		d.render(w, "message.html", "Fitbit client ID and secret are not configured. Run fitbit-gettoken first.")
+		return
+	}
+
+	flow := htf.NewFitbitAuthFlow(htf.GetFitbitConfig(cfg.Fitbit.ClientID, cfg.Fitbit.ClientSecret))
+	d.mu.Lock()
+	d.authFlow = flow
+	d.mu.Unlock()
+
+	http.Redirect(w, r, flow.AuthCodeURL(), http.StatusFound)
+}
+
+func (d *dashboard) handleCallback(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	flow := d.authFlow
+	// A callback with another state is not the redirect of this
+	// authorization; keep waiting for it
+	valid := flow != nil && flow.ValidState(r.URL.Query().Get("state"))
+	if valid {
+		d.authFlow = nil
+	}
+	d.mu.Unlock()
+
+	if flow == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		d.render(w, "message.html", "No authorization in progress.")
+		return
+	}
+	if !valid {
+		slog.Warn("refused callback with an invalid state", "provider", "fitbit")
+		w.WriteHeader(http.StatusBadRequest)
+		d.render(w, "message.html", "Invalid authorization state. Start the authorization again.")
+		return
+	}
+
+	token, err := flow.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		slog.Error("failed to exchange token", "provider", "fitbit", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		d.render(w, "message.html", "Failed to authorize Fitbit.")
+		return
+	}
+
+	cfg := loadConfig()
+	cfg.Fitbit.AccessToken = token.AccessToken
+	cfg.Fitbit.RefreshToken = token.RefreshToken
+	cfg.Fitbit.Expiry = token.Expiry
+	if err := config.SaveConfig(cfg); err != nil {
+		slog.Error("failed to save config", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		d.render(w, "message.html", "Failed to save the Fitbit token.")
+		return
+	}
+
+	slog.Info("fitbit re-authorized", "provider", "fitbit")
+	d.render(w, "message.html", "Fitbit re-authorized. Credentials saved to config file.")
+}
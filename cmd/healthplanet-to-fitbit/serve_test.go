@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	htf "healthplanet-to-fitbit"
+	"healthplanet-to-fitbit/config"
+
+	"golang.org/x/oauth2"
+)
+
+func newTestDashboard(t *testing.T) (*dashboard, http.Handler) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	static, err := fs.Sub(webFS, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &dashboard{days: 90}
+	return d, d.routes(static)
+}
+
+func TestDashboard_SameOrigin(t *testing.T) {
+	const key = "2023-01-01 07:00"
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"same origin", map[string]string{"Origin": "http://localhost:8080"}, http.StatusSeeOther},
+		{"fetch metadata", map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://localhost:8080"}, http.StatusSeeOther},
+		{"no browser headers", nil, http.StatusSeeOther},
+		{"other origin", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"other port", map[string]string{"Origin": "http://localhost:9090"}, http.StatusForbidden},
+		{"cross site", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, http.StatusForbidden},
+		{"same site", map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{"opaque origin", map[string]string{"Origin": "null"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, handler := newTestDashboard(t)
+			q, err := config.LoadQuarantine()
+			if err != nil {
+				t.Fatal(err)
+			}
+			q.Add(key, config.QuarantineEntry{Time: time.Now(), Reason: "invalid weight"})
+			if err := config.SaveQuarantine(q); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "http://localhost:8080/quarantine/dismiss", strings.NewReader(url.Values{"key": {key}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+
+			q, err = config.LoadQuarantine()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dismissed := !q.Has(key); dismissed != (tt.want == http.StatusSeeOther) {
+				t.Errorf("dismissed = %v", dismissed)
+			}
+		})
+	}
+
+	// A cross-origin request cannot start a sync
+	d, handler := newTestDashboard(t)
+	req := httptest.NewRequest(http.MethodPost, "http://localhost:8080/sync", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || d.isSyncRunning() {
+		t.Errorf("status = %d, sync running = %v", rec.Code, d.isSyncRunning())
+	}
+}
+
+func TestDashboard_CallbackState(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"new-access-token","refresh_token":"new-refresh-token","token_type":"Bearer","expires_in":28800}`))
+	}))
+	defer tokenServer.Close()
+
+	d, handler := newTestDashboard(t)
+	cfg := htf.GetFitbitConfig("id", "secret")
+	cfg.Endpoint = oauth2.Endpoint{AuthURL: tokenServer.URL + "/authorize", TokenURL: tokenServer.URL + "/token"}
+	flow := htf.NewFitbitAuthFlow(cfg)
+	d.authFlow = flow
+
+	authURL, err := url.Parse(flow.AuthCodeURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := authURL.Query().Get("state"); state != flow.State || len(state) < 32 {
+		t.Fatalf("state = %q, want the random state of the flow", state)
+	}
+	if other := htf.NewFitbitAuthFlow(cfg); other.State == flow.State {
+		t.Errorf("flows share the state %q", flow.State)
+	}
+
+	callback := func(state string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/callback?code=c0de&state="+url.QueryEscape(state), nil))
+		return rec
+	}
+
+	// A forged callback is refused and does not end the authorization
+	for _, state := range []string{"", "state", flow.State + "x"} {
+		if rec := callback(state); rec.Code != http.StatusBadRequest {
+			t.Errorf("callback(%q) status = %d, want %d", state, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if saved, err := config.LoadConfig(); err != nil || saved.Fitbit.AccessToken != "" {
+		t.Fatalf("config = %+v, %v, want no token", saved, err)
+	}
+
+	if rec := callback(flow.State); rec.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", rec.Code, rec.Body)
+	}
+	saved, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Fitbit.AccessToken != "new-access-token" || saved.Fitbit.RefreshToken != "new-refresh-token" {
+		t.Errorf("config = %+v", saved.Fitbit)
+	}
+
+	// The state is used once
+	if rec := callback(flow.State); rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback status = %d", rec.Code)
+	}
+}
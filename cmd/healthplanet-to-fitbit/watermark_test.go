@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	htf "healthplanet-to-fitbit"
+	"healthplanet-to-fitbit/config"
+)
+
+var jst = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+func newWatermarks(t *testing.T) *config.Watermarks {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	w, err := config.LoadWatermarks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestStartDate(t *testing.T) {
+	w := newWatermarks(t)
+	if got := startDate("", w, watermarkInnerScan); got != "" {
+		t.Errorf("startDate() without watermark = %q, want the default range", got)
+	}
+
+	// 22:30 UTC is the next day in JST
+	w.Advance(watermarkInnerScan, time.Date(2023, 1, 1, 22, 30, 0, 0, time.UTC))
+	if got := startDate("", w, watermarkInnerScan); got != "2023-01-02" {
+		t.Errorf("startDate() = %q, want the JST day of the watermark", got)
+	}
+	if got := startDate("2022-12-01", w, watermarkInnerScan); got != "2022-12-01" {
+		t.Errorf("startDate(--from) = %q, want --from", got)
+	}
+	if got := startDate("", w, watermarkPedometer); got != "" {
+		t.Errorf("startDate(pedometer) = %q, want the default range", got)
+	}
+}
+
+func TestAdvanceWatermark(t *testing.T) {
+	mark := time.Date(2023, 1, 10, 7, 0, 0, 0, jst)
+	tests := []struct {
+		name    string
+		start   string
+		through time.Time
+		want    time.Time
+	}{
+		{"from the watermark", "2023-01-10", mark.AddDate(0, 0, 5), mark.AddDate(0, 0, 5)},
+		{"from before the watermark", "2023-01-01", mark.AddDate(0, 0, 5), mark.AddDate(0, 0, 5)},
+		{"default range", "", mark.AddDate(0, 0, 5), mark.AddDate(0, 0, 5)},
+		// The days between the watermark and --from were not synced
+		{"from after the watermark", "2023-01-12", mark.AddDate(0, 0, 5), mark},
+		// A resync of older days does not move it back
+		{"behind the watermark", "2023-01-01", mark.AddDate(0, 0, -5), mark},
+		{"nothing synced", "2023-01-10", time.Time{}, mark},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWatermarks(t)
+			w.Advance(watermarkInnerScan, mark)
+			advanceWatermark(w, watermarkInnerScan, tt.start, tt.through)
+			if m, _ := w.Get(watermarkInnerScan); !m.SyncedThrough.Equal(tt.want) {
+				t.Errorf("SyncedThrough = %v, want %v", m.SyncedThrough, tt.want)
+			}
+		})
+	}
+
+	// The first run sets it
+	w := newWatermarks(t)
+	advanceWatermark(w, watermarkInnerScan, "2023-01-12", mark)
+	if m, _ := w.Get(watermarkInnerScan); !m.SyncedThrough.Equal(mark) {
+		t.Errorf("SyncedThrough = %v, want %v", m.SyncedThrough, mark)
+	}
+}
+
+// failingSink fails the writes of the reading at fail.
+type failingSink struct {
+	*htf.FileSink
+	fail time.Time
+}
+
+func (s *failingSink) WriteMeasurement(ctx context.Context, m htf.Measurement) error {
+	if m.Time.Equal(s.fail) {
+		return errors.New("connection reset")
+	}
+	return s.FileSink.WriteMeasurement(ctx, m)
+}
+
+func TestAdvanceWatermark_NewestFirst(t *testing.T) {
+	w := newWatermarks(t)
+	mark := time.Date(2023, 1, 1, 7, 0, 0, 0, jst)
+	w.Advance(watermarkInnerScan, mark)
+
+	weight := 70.0
+	t1, t2, t3 := mark.AddDate(0, 0, 1), mark.AddDate(0, 0, 2), mark.AddDate(0, 0, 3)
+	syncer := &htf.Syncer{
+		Source: htf.SourceFunc(func(ctx context.Context) (htf.AggregatedInnerScanDataMap, error) {
+			return htf.AggregatedInnerScanDataMap{t1: {Weight: &weight}, t2: {Weight: &weight}, t3: {Weight: &weight}}, nil
+		}),
+		// t3 and t2 are written, then t1 fails
+		Sink:        &failingSink{FileSink: &htf.FileSink{Path: filepath.Join(t.TempDir(), "sink.jsonl")}, fail: t1},
+		NewestFirst: true,
+		Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	result, err := syncer.Run(context.Background())
+	if err == nil || result.Created != 2 {
+		t.Fatalf("Run() = %d created, error %v", result.Created, err)
+	}
+
+	advanceWatermark(w, watermarkInnerScan, "2023-01-01", result.SyncedThrough)
+	if m, _ := w.Get(watermarkInnerScan); !m.SyncedThrough.Equal(mark) {
+		t.Errorf("SyncedThrough = %v, want it to stay at %v", m.SyncedThrough, mark)
+	}
+}
+
+func TestRegistrationRange(t *testing.T) {
+	w := newWatermarks(t)
+	defaultFrom, defaultTo := apiDateRange("", "")
+
+	// The first registration mode run fetches the default range
+	if from, to := registrationRange("", "", w, watermarkInnerScan); from != defaultFrom || to != defaultTo {
+		t.Errorf("registrationRange() = %s, %s, want %s, %s", from, to, defaultFrom, defaultTo)
+	}
+
+	registered := time.Date(2023, 1, 10, 12, 0, 0, 0, jst)
+	w.AdvanceRegistered(watermarkInnerScan, registered)
+	w.SetMode(watermarkInnerScan, htf.DateModeRegistration)
+	if from, to := registrationRange("", "", w, watermarkInnerScan); from != "20230110110000" || to != defaultTo {
+		t.Errorf("registrationRange() = %s, %s, want an hour before the registration watermark", from, to)
+	}
+
+	// --from and --to win over the watermark
+	if from, to := registrationRange("2023-01-01", "2023-01-05", w, watermarkInnerScan); from != "20230101000000" || to != "20230105235959" {
+		t.Errorf("registrationRange(--from, --to) = %s, %s", from, to)
+	}
+
+	// After a run in measurement mode, the registration watermark may have
+	// missed readings registered meanwhile
+	w.SetMode(watermarkInnerScan, htf.DateModeMeasurement)
+	if from, _ := registrationRange("", "", w, watermarkInnerScan); from != defaultFrom {
+		t.Errorf("registrationRange() after switching modes = %s, want %s", from, defaultFrom)
+	}
+}
@@ -1,15 +1,37 @@
 package config
 
 import (
+	"bufio"
 	"encoding/json"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
+)
+
+// Keys added to a loaded cache are appended to a journal next to cache.json
+// as soon as they are added, so the record of what a run wrote survives a
+// crash or kill before SaveCache. LoadCache replays the journal and
+// SaveCache compacts it into cache.json.
+const (
+	cacheFile        = "cache.json"
+	cacheJournalFile = "cache.journal"
 )
 
 type Cache struct {
-	ProcessedDates map[string]bool `json:"processed_dates"`
-	mu             sync.RWMutex
+	ProcessedDates     map[string]bool `json:"processed_dates"`
+	LastSuccessfulSync time.Time       `json:"last_successful_sync"`
+	mu                 sync.RWMutex
+
+	// journalPath is empty for caches that were not loaded from disk.
+	journalPath string
+	journal     *os.File
+	journalErr  error
+}
+
+type cacheJournalEntry struct {
+	Key string `json:"key"`
 }
 
 func LoadCache() (*Cache, error) {
@@ -18,58 +40,141 @@ func LoadCache() (*Cache, error) {
 		return nil, err
 	}
 
-	path := filepath.Join(dir, "cache.json")
-	f, err := os.Open(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return &Cache{
-				ProcessedDates: make(map[string]bool),
-			}, nil
-		}
+	c := &Cache{
+		ProcessedDates: make(map[string]bool),
+		journalPath:    filepath.Join(dir, cacheJournalFile),
+	}
+
+	f, err := os.Open(filepath.Join(dir, cacheFile))
+	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
-	defer f.Close()
+	if err == nil {
+		defer f.Close()
+		if err := json.NewDecoder(f).Decode(c); err != nil {
+			return nil, err
+		}
+		if c.ProcessedDates == nil {
+			c.ProcessedDates = make(map[string]bool)
+		}
+	}
 
-	var c Cache
-	if err := json.NewDecoder(f).Decode(&c); err != nil {
+	if err := c.replayJournal(); err != nil {
 		return nil, err
 	}
-	if c.ProcessedDates == nil {
-		c.ProcessedDates = make(map[string]bool)
+
+	return c, nil
+}
+
+// replayJournal adds the keys journaled since the last SaveCache.
+func (c *Cache) replayJournal() error {
+	f, err := os.Open(c.journalPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
 	}
+	defer f.Close()
 
-	return &c, nil
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e cacheJournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Key == "" {
+			// The last line may be cut short by a crash while writing it
+			continue
+		}
+		c.ProcessedDates[e.Key] = true
+	}
+	return scanner.Err()
 }
 
+// SaveCache writes the cache to cache.json and clears the journal.
 func SaveCache(c *Cache) error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	dir, err := GetConfigDir()
 	if err != nil {
 		return err
 	}
 
-	if err := os.MkdirAll(dir, 0700); err != nil {
+	if err := writeJSONFile(filepath.Join(dir, cacheFile), c); err != nil {
 		return err
 	}
 
-	path := filepath.Join(dir, "cache.json")
-	f, err := os.Create(path)
-	if err != nil {
-		return err
+	// Everything in the journal is now in cache.json
+	if c.journal != nil {
+		c.journal.Close()
+		c.journal = nil
+	}
+	if c.journalPath != "" {
+		if err := os.Remove(c.journalPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
-	defer f.Close()
 
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	return enc.Encode(c)
+	return nil
 }
 
 func (c *Cache) Add(date string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.ProcessedDates[date] {
+		return
+	}
 	c.ProcessedDates[date] = true
+
+	if err := c.appendJournal(date); err != nil && c.journalErr == nil {
+		// Keep going; the key is still saved by SaveCache
+		c.journalErr = err
+		slog.Warn("failed to write cache journal", "path", c.journalPath, "error", err)
+	}
+}
+
+func (c *Cache) appendJournal(key string) error {
+	if c.journalPath == "" || c.journalErr != nil {
+		return nil
+	}
+
+	if c.journal == nil {
+		if err := os.MkdirAll(filepath.Dir(c.journalPath), 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(c.journalPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		c.journal = f
+		if err := c.endCutLine(); err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(cacheJournalEntry{Key: key})
+	if err != nil {
+		return err
+	}
+	_, err = c.journal.Write(append(line, '\n'))
+	return err
+}
+
+// endCutLine ends a last line cut short by a crash, so that the next entry
+// is not appended to it.
+func (c *Cache) endCutLine() error {
+	info, err := c.journal.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := c.journal.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = c.journal.Write([]byte{'\n'})
+	return err
 }
 
 func (c *Cache) Has(date string) bool {
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupConfigDir points the config dir to a temporary HOME.
+func setupConfigDir(t *testing.T) string {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	dir, err := GetConfigDir()
+	if err != nil {
+		t.Fatalf("GetConfigDir() error = %v", err)
+	}
+	return dir
+}
+
+func TestCacheJournal(t *testing.T) {
+	dir := setupConfigDir(t)
+
+	c, err := LoadCache()
+	if err != nil {
+		t.Fatalf("LoadCache() error = %v", err)
+	}
+	c.Add("2023-01-01 07:00")
+	c.Add("2023-01-02 07:00")
+	if err := SaveCache(c); err != nil {
+		t.Fatalf("SaveCache() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, cacheJournalFile)); !os.IsNotExist(err) {
+		t.Errorf("journal exists after SaveCache: %v", err)
+	}
+
+	// The run is killed after adding a key, before SaveCache
+	c, err = LoadCache()
+	if err != nil {
+		t.Fatalf("LoadCache() error = %v", err)
+	}
+	c.Add("2023-01-03 07:00")
+	c.Add("2023-01-03 07:00")
+	if c.journal != nil {
+		c.journal.Close()
+	}
+
+	c, err = LoadCache()
+	if err != nil {
+		t.Fatalf("LoadCache() after unclean exit error = %v", err)
+	}
+	for _, key := range []string{"2023-01-01 07:00", "2023-01-02 07:00", "2023-01-03 07:00"} {
+		if !c.Has(key) {
+			t.Errorf("Has(%q) = false", key)
+		}
+	}
+
+	if err := SaveCache(c); err != nil {
+		t.Fatalf("SaveCache() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, cacheJournalFile)); !os.IsNotExist(err) {
+		t.Errorf("journal exists after SaveCache: %v", err)
+	}
+	c, err = LoadCache()
+	if err != nil {
+		t.Fatalf("LoadCache() error = %v", err)
+	}
+	if len(c.ProcessedDates) != 3 {
+		t.Errorf("ProcessedDates = %v, want 3 keys", c.ProcessedDates)
+	}
+}
+
+func TestCacheJournal_TruncatedLine(t *testing.T) {
+	dir := setupConfigDir(t)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash while writing the second entry
+	journal := `{"key":"2023-01-01 07:00"}` + "\n" + `{"key":"2023-01-0`
+	if err := os.WriteFile(filepath.Join(dir, cacheJournalFile), []byte(journal), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadCache()
+	if err != nil {
+		t.Fatalf("LoadCache() error = %v", err)
+	}
+	if !c.Has("2023-01-01 07:00") || len(c.ProcessedDates) != 1 {
+		t.Errorf("ProcessedDates = %v, want only the complete entry", c.ProcessedDates)
+	}
+
+	// New entries start on a line of their own after the cut one
+	c.Add("2023-01-02 07:00")
+	c.journal.Close()
+	c, err = LoadCache()
+	if err != nil {
+		t.Fatalf("LoadCache() error = %v", err)
+	}
+	if !c.Has("2023-01-02 07:00") {
+		t.Errorf("ProcessedDates = %v, want the entry added after the cut one", c.ProcessedDates)
+	}
+}
+
+func TestCache_NotLoaded(t *testing.T) {
+	dir := setupConfigDir(t)
+
+	// A cache not loaded from disk does not journal
+	c := &Cache{ProcessedDates: map[string]bool{}}
+	c.Add("2023-01-01 07:00")
+	if _, err := os.Stat(filepath.Join(dir, cacheJournalFile)); !os.IsNotExist(err) {
+		t.Errorf("journal written for a cache not loaded from disk: %v", err)
+	}
+}
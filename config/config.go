@@ -4,21 +4,104 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type Config struct {
 	HealthPlanet struct {
-		ClientID     string `json:"client_id"`
-		ClientSecret string `json:"client_secret"`
-		AccessToken  string `json:"access_token"`
-		RefreshToken string `json:"refresh_token"`
+		ClientID     string    `json:"client_id"`
+		ClientSecret string    `json:"client_secret"`
+		AccessToken  string    `json:"access_token"`
+		RefreshToken string    `json:"refresh_token"`
+		Expiry       time.Time `json:"expiry"`
+		// DateMode selects the readings to fetch by measurement date
+		// (measurement) or by the date they were uploaded (registration).
+		// Defaults to measurement.
+		DateMode string `json:"date_mode"`
 	} `json:"health_planet"`
 	Fitbit struct {
-		ClientID     string `json:"client_id"`
-		ClientSecret string `json:"client_secret"`
-		AccessToken  string `json:"access_token"`
-		RefreshToken string `json:"refresh_token"`
+		ClientID     string    `json:"client_id"`
+		ClientSecret string    `json:"client_secret"`
+		AccessToken  string    `json:"access_token"`
+		RefreshToken string    `json:"refresh_token"`
+		Expiry       time.Time `json:"expiry"`
+		// UpdateHeight sets the height in the Fitbit profile to the one
+		// registered in HealthPlanet when they differ.
+		UpdateHeight bool `json:"update_height"`
+		// RollbackPartial deletes the weight log of a reading whose fat log
+		// cannot be written, instead of retrying only the fat next time.
+		RollbackPartial bool `json:"rollback_partial"`
 	} `json:"fitbit"`
+	Sinks struct {
+		File struct {
+			Path   string `json:"path"`
+			Format string `json:"format"`
+		} `json:"file"`
+		InfluxDB struct {
+			URL         string `json:"url"`
+			Database    string `json:"database"`
+			Measurement string `json:"measurement"`
+			Token       string `json:"token"`
+		} `json:"influxdb"`
+	} `json:"sinks"`
+	Notify struct {
+		Webhook struct {
+			URL string `json:"url"`
+		} `json:"webhook"`
+		Slack struct {
+			URL string `json:"url"`
+		} `json:"slack"`
+		Discord struct {
+			URL string `json:"url"`
+		} `json:"discord"`
+		SMTP struct {
+			Addr     string   `json:"addr"`
+			Username string   `json:"username"`
+			Password string   `json:"password"`
+			From     string   `json:"from"`
+			To       []string `json:"to"`
+		} `json:"smtp"`
+		// TokenExpiryWarningDays is how many days before expiry a token
+		// warning is sent. Defaults to 7.
+		TokenExpiryWarningDays int `json:"token_expiry_warning_days"`
+	} `json:"notify"`
+	Units struct {
+		// Weight is the weight unit of the Fitbit account (kg, lb). Empty
+		// or "auto" reads it from the Fitbit profile.
+		Weight string `json:"weight"`
+		// Rounding is how values are rounded before they are written to
+		// Fitbit (nearest, down, up). Defaults to nearest.
+		Rounding string `json:"rounding"`
+		// Decimals is the number of decimal places kept. Defaults to 2.
+		Decimals *int `json:"decimals"`
+	} `json:"units"`
+	Pedometer struct {
+		// Enabled syncs the daily step counts of HealthPlanet pedometers
+		// to Fitbit as activity logs.
+		Enabled bool `json:"enabled"`
+		// ActivityID is the Fitbit activity the steps are logged as.
+		// Defaults to 90013 (Walk).
+		ActivityID int64 `json:"activity_id"`
+	} `json:"pedometer"`
+	Aggregation struct {
+		// Policy selects how the readings of a day are reduced before they
+		// are written to Fitbit (all, first, last, min, mean, median).
+		// Defaults to all.
+		Policy string `json:"policy"`
+	} `json:"aggregation"`
+	Trend struct {
+		// Alpha is the smoothing factor of the weight trend, in (0, 1].
+		// Defaults to 0.1.
+		Alpha float64 `json:"alpha"`
+		// PushToSinks writes the trend to the additional sinks along with
+		// the raw readings.
+		PushToSinks bool `json:"push_to_sinks"`
+	} `json:"trend"`
+	Retry struct {
+		// MaxAttempts is how many times an API call is attempted after
+		// network errors, server errors and rate limiting. Defaults to 3.
+		MaxAttempts int `json:"max_attempts"`
+	} `json:"retry"`
 }
 
 func GetConfigDir() (string, error) {
@@ -29,6 +112,24 @@ func GetConfigDir() (string, error) {
 	return filepath.Join(home, ".config", "healthplanet-to-fitbit"), nil
 }
 
+// LedgerPath returns the path of the local copy of the synced readings.
+func LedgerPath() (string, error) {
+	dir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ledger.jsonl"), nil
+}
+
+// RunLogPath returns the path of the history of sync runs.
+func RunLogPath() (string, error) {
+	dir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "runs.jsonl"), nil
+}
+
 func LoadConfig() (*Config, error) {
 	dir, err := GetConfigDir()
 	if err != nil {
@@ -53,24 +154,44 @@ func LoadConfig() (*Config, error) {
 	return &cfg, nil
 }
 
+// SaveConfig writes cfg atomically, so a crash while saving a refreshed
+// token never leaves a truncated config behind.
 func SaveConfig(cfg *Config) error {
 	dir, err := GetConfigDir()
 	if err != nil {
 		return err
 	}
 
+	return writeJSONFile(filepath.Join(dir, "config.json"), cfg)
+}
+
+// writeJSONFile writes v as indented JSON to a temporary file in the same
+// directory and renames it to path.
+func writeJSONFile(path string, v any) error {
+	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
 	}
 
-	path := filepath.Join(dir, "config.json")
-	f, err := os.Create(path)
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer os.Remove(f.Name())
 
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "  ")
-	return enc.Encode(cfg)
+	if err := enc.Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
 }
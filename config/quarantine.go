@@ -0,0 +1,112 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// QuarantineEntry is a reading Fitbit rejected. It is skipped by the sync
+// until it is released or dismissed.
+type QuarantineEntry struct {
+	Time          time.Time `json:"time"`
+	Weight        *float64  `json:"weight,omitempty"`
+	Fat           *float64  `json:"fat,omitempty"`
+	Reason        string    `json:"reason"`
+	StatusCode    int       `json:"status_code,omitempty"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+type Quarantine struct {
+	Entries map[string]QuarantineEntry `json:"entries"`
+	mu      sync.RWMutex
+}
+
+func LoadQuarantine() (*Quarantine, error) {
+	dir, err := GetConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, "quarantine.json")
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Quarantine{
+				Entries: make(map[string]QuarantineEntry),
+			}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var q Quarantine
+	if err := json.NewDecoder(f).Decode(&q); err != nil {
+		return nil, err
+	}
+	if q.Entries == nil {
+		q.Entries = make(map[string]QuarantineEntry)
+	}
+
+	return &q, nil
+}
+
+func SaveQuarantine(q *Quarantine) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	dir, err := GetConfigDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, "quarantine.json")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(q)
+}
+
+func (q *Quarantine) Add(key string, e QuarantineEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Entries[key] = e
+}
+
+func (q *Quarantine) Has(key string) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	_, ok := q.Entries[key]
+	return ok
+}
+
+func (q *Quarantine) Remove(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.Entries, key)
+}
+
+// Keys returns the keys of all entries in chronological order.
+func (q *Quarantine) Keys() []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	keys := make([]string, 0, len(q.Entries))
+	for k := range q.Entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
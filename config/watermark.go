@@ -0,0 +1,119 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Watermark records how far a profile is synced.
+type Watermark struct {
+	// SyncedThrough is the time of the latest reading such that it and every
+	// earlier reading are in Fitbit (or were deliberately skipped).
+	SyncedThrough time.Time `json:"synced_through"`
+	// Mode is the HealthPlanet date mode of the last run (measurement,
+	// registration).
+	Mode string `json:"mode,omitempty"`
+	// RegisteredThrough is the start of the last registration mode run that
+	// synced everything it fetched: readings registered before it are in
+	// Fitbit.
+	RegisteredThrough time.Time `json:"registered_through"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// Watermarks holds the watermark of each profile: the kind of data synced,
+// such as "innerscan" or "pedometer".
+type Watermarks struct {
+	Profiles map[string]Watermark `json:"profiles"`
+	mu       sync.RWMutex
+}
+
+func LoadWatermarks() (*Watermarks, error) {
+	dir, err := GetConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, "watermark.json")
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Watermarks{
+				Profiles: make(map[string]Watermark),
+			}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var w Watermarks
+	if err := json.NewDecoder(f).Decode(&w); err != nil {
+		return nil, err
+	}
+	if w.Profiles == nil {
+		w.Profiles = make(map[string]Watermark)
+	}
+
+	return &w, nil
+}
+
+func SaveWatermarks(w *Watermarks) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	dir, err := GetConfigDir()
+	if err != nil {
+		return err
+	}
+
+	return writeJSONFile(filepath.Join(dir, "watermark.json"), w)
+}
+
+// Get returns the watermark of profile and whether there is one.
+func (w *Watermarks) Get(profile string) (Watermark, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	m, ok := w.Profiles[profile]
+	return m, ok
+}
+
+// Advance moves the watermark of profile forward to t. It never moves back.
+func (w *Watermarks) Advance(profile string, t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := w.Profiles[profile]
+	if !t.After(m.SyncedThrough) {
+		return
+	}
+	m.SyncedThrough = t
+	m.UpdatedAt = time.Now()
+	w.Profiles[profile] = m
+}
+
+// SetMode records the date mode of a run of profile.
+func (w *Watermarks) SetMode(profile, mode string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := w.Profiles[profile]
+	if m.Mode == mode {
+		return
+	}
+	m.Mode = mode
+	m.UpdatedAt = time.Now()
+	w.Profiles[profile] = m
+}
+
+// AdvanceRegistered moves the registration watermark of profile forward to t.
+func (w *Watermarks) AdvanceRegistered(profile string, t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := w.Profiles[profile]
+	if !t.After(m.RegisteredThrough) {
+		return
+	}
+	m.RegisteredThrough = t
+	m.UpdatedAt = time.Now()
+	w.Profiles[profile] = m
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatermarks(t *testing.T) {
+	setupConfigDir(t)
+
+	w, err := LoadWatermarks()
+	if err != nil {
+		t.Fatalf("LoadWatermarks() error = %v", err)
+	}
+	if _, ok := w.Get("innerscan"); ok {
+		t.Error("Get() found a watermark before any run")
+	}
+
+	t1 := time.Date(2023, 1, 1, 7, 0, 0, 0, time.UTC)
+	t2 := t1.AddDate(0, 0, 1)
+
+	w.Advance("innerscan", t2)
+	// It never moves back
+	w.Advance("innerscan", t1)
+	w.Advance("innerscan", time.Time{})
+	if m, _ := w.Get("innerscan"); !m.SyncedThrough.Equal(t2) {
+		t.Errorf("SyncedThrough = %v, want %v", m.SyncedThrough, t2)
+	}
+
+	w.AdvanceRegistered("innerscan", t2)
+	w.AdvanceRegistered("innerscan", t1)
+	if m, _ := w.Get("innerscan"); !m.RegisteredThrough.Equal(t2) || !m.SyncedThrough.Equal(t2) {
+		t.Errorf("watermark = %+v", m)
+	}
+
+	// Switching modes keeps both watermarks
+	w.SetMode("innerscan", "registration")
+	w.SetMode("innerscan", "measurement")
+	m, _ := w.Get("innerscan")
+	if m.Mode != "measurement" || !m.RegisteredThrough.Equal(t2) || !m.SyncedThrough.Equal(t2) {
+		t.Errorf("watermark = %+v", m)
+	}
+
+	// Profiles are independent
+	if _, ok := w.Get("pedometer"); ok {
+		t.Error("Get(pedometer) found a watermark")
+	}
+
+	if err := SaveWatermarks(w); err != nil {
+		t.Fatalf("SaveWatermarks() error = %v", err)
+	}
+	loaded, err := LoadWatermarks()
+	if err != nil {
+		t.Fatalf("LoadWatermarks() error = %v", err)
+	}
+	if got, _ := loaded.Get("innerscan"); got.Mode != m.Mode || !got.SyncedThrough.Equal(m.SyncedThrough) || !got.RegisteredThrough.Equal(m.RegisteredThrough) {
+		t.Errorf("loaded watermark = %+v, want %+v", got, m)
+	}
+}
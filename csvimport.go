@@ -0,0 +1,169 @@
+package htf
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+var csvTimeLayouts = []string{
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"2006/1/2 15:04:05",
+	"2006/1/2 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006年01月02日 15:04:05",
+	"2006年01月02日 15:04",
+	"2006年1月2日 15:04",
+	"200601021504",
+}
+
+type csvColumns struct {
+	dateTime, date, clock, weight, fat int
+}
+
+// ParseHealthPlanetCSV parses the CSV files downloaded from the HealthPlanet
+// website. Files are Shift_JIS encoded with Japanese headers; UTF-8 files
+// are accepted as well. Times are interpreted as JST.
+func ParseHealthPlanetCSV(r io.Reader) (AggregatedInnerScanDataMap, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read csv")
+	}
+
+	if utf8.Valid(raw) {
+		raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
+	} else {
+		raw, _, err = transform.Bytes(japanese.ShiftJIS.NewDecoder(), raw)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode shift_jis")
+		}
+	}
+
+	cr := csv.NewReader(bytes.NewReader(raw))
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse csv")
+	}
+	if len(records) == 0 {
+		return nil, errors.New("empty csv")
+	}
+
+	cols, err := findCSVColumns(records[0])
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(AggregatedInnerScanDataMap, len(records)-1)
+	for i, rec := range records[1:] {
+		line := i + 2
+
+		t, err := cols.time(rec)
+		if err != nil {
+			slog.Warn("invalid time", "line", line, "error", err)
+			continue
+		}
+
+		weight, err := csvFloat(rec, cols.weight)
+		if err != nil {
+			slog.Warn("invalid weight", "line", line, "metric", "weight", "error", err)
+			continue
+		}
+		if weight == nil {
+			continue
+		}
+
+		fat, err := csvFloat(rec, cols.fat)
+		if err != nil {
+			slog.Warn("invalid fat", "line", line, "metric", "fat", "error", err)
+		}
+
+		m[t] = &AggregatedInnerScanData{
+			Weight: weight,
+			Fat:    fat,
+		}
+	}
+
+	return m, nil
+}
+
+func findCSVColumns(header []string) (csvColumns, error) {
+	cols := csvColumns{dateTime: -1, date: -1, clock: -1, weight: -1, fat: -1}
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		switch {
+		case strings.Contains(h, "日時"):
+			cols.dateTime = i
+		case strings.Contains(h, "日付") || h == "測定日":
+			cols.date = i
+		case strings.Contains(h, "時刻"):
+			cols.clock = i
+		case strings.HasPrefix(h, "体重"):
+			cols.weight = i
+		case strings.HasPrefix(h, "体脂肪率"):
+			cols.fat = i
+		}
+	}
+
+	if cols.dateTime < 0 && cols.date < 0 {
+		return cols, errors.Errorf("date column not found in header: %v", header)
+	}
+	if cols.weight < 0 {
+		return cols, errors.Errorf("weight column not found in header: %v", header)
+	}
+
+	return cols, nil
+}
+
+func (c csvColumns) time(rec []string) (time.Time, error) {
+	var s string
+	if c.dateTime >= 0 && c.dateTime < len(rec) {
+		s = rec[c.dateTime]
+	} else if c.date >= 0 && c.date < len(rec) {
+		s = rec[c.date]
+		if c.clock >= 0 && c.clock < len(rec) {
+			s += " " + rec[c.clock]
+		}
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		// Short rows, such as footers, have no time
+		return time.Time{}, errors.Errorf("no time in row: %v", rec)
+	}
+
+	for _, layout := range csvTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, tz); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("unknown time format: %q", s)
+}
+
+func csvFloat(rec []string, i int) (*float64, error) {
+	if i < 0 || i >= len(rec) {
+		return nil, nil
+	}
+
+	s := strings.TrimSpace(rec[i])
+	if s == "" || s == "-" || s == "--" {
+		return nil, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
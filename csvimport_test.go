@@ -0,0 +1,76 @@
+package htf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+func TestParseHealthPlanetCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+	}{
+		{
+			name: "Date and time in one column",
+			csv: "測定日時,体重(kg),体脂肪率(%),筋肉量(kg)\n" +
+				"2023/01/01 12:00,70.5,20.5,50.0\n" +
+				"2023/01/02 07:30,70.1,,50.1\n" +
+				"2023/01/03 07:30,-,19.9,50.1\n" +
+				"invalid,70.0,20.0,50.0\n",
+		},
+		{
+			name: "Date and time in separate columns",
+			csv: "日付,測定時刻,体重(kg),体脂肪率(%)\n" +
+				"2023年01月01日,12:00,70.5,20.5\n" +
+				"2023年01月02日,07:30,70.1,\n",
+		},
+		{
+			name: "Short footer row",
+			csv: "体重(kg),体脂肪率(%),測定日時\n" +
+				"70.5,20.5,2023/01/01 12:00\n" +
+				"70.1,,2023/01/02 07:30\n" +
+				"70.3\n" +
+				"合計\n",
+		},
+	}
+
+	t1 := time.Date(2023, 1, 1, 12, 0, 0, 0, tz).UTC()
+	t2 := time.Date(2023, 1, 2, 7, 30, 0, 0, tz).UTC()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sjis, _, err := transform.String(japanese.ShiftJIS.NewEncoder(), tt.csv)
+			if err != nil {
+				t.Fatalf("failed to encode shift_jis: %v", err)
+			}
+
+			for _, input := range []string{sjis, tt.csv} {
+				got, err := ParseHealthPlanetCSV(bytes.NewBufferString(input))
+				if err != nil {
+					t.Fatalf("ParseHealthPlanetCSV() error = %v", err)
+				}
+				if len(got) != 2 {
+					t.Fatalf("ParseHealthPlanetCSV() got %d items, want 2", len(got))
+				}
+				if d := got[t1]; d == nil || *d.Weight != 70.5 || d.Fat == nil || *d.Fat != 20.5 {
+					t.Errorf("data at %v = %+v", t1, d)
+				}
+				if d := got[t2]; d == nil || *d.Weight != 70.1 || d.Fat != nil {
+					t.Errorf("data at %v = %+v", t2, d)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHealthPlanetCSV_MissingColumns(t *testing.T) {
+	_, err := ParseHealthPlanetCSV(strings.NewReader("foo,bar\n1,2\n"))
+	if err == nil {
+		t.Error("ParseHealthPlanetCSV() error = nil, want error")
+	}
+}
@@ -0,0 +1,100 @@
+package htf
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// HeightCm returns the height registered in HealthPlanet, in centimeters.
+func (p *InnerScanProfile) HeightCm() (float64, bool) {
+	if p == nil {
+		return 0, false
+	}
+	h, err := strconv.ParseFloat(p.Height, 64)
+	if err != nil || h <= 0 {
+		return 0, false
+	}
+	return h, true
+}
+
+// BMI returns the body mass index for a weight in kilograms and a height in
+// centimeters.
+func BMI(weightKg, heightCm float64) float64 {
+	m := heightCm / 100
+	return weightKg / (m * m)
+}
+
+// ApplyDerived computes BMI (from the HealthPlanet height), fat mass and lean
+// body mass for every reading that has the values they depend on.
+func (m AggregatedInnerScanDataMap) ApplyDerived() {
+	round := func(v float64) *float64 {
+		v = math.Round(v*100) / 100
+		return &v
+	}
+
+	for _, d := range m {
+		if d.Weight == nil {
+			continue
+		}
+		if h, ok := d.Profile.HeightCm(); ok {
+			d.BMI = round(BMI(*d.Weight, h))
+		}
+		if d.Fat != nil {
+			fatMass := *d.Weight * *d.Fat / 100
+			d.FatMass = round(fatMass)
+			d.LeanMass = round(*d.Weight - fatMass)
+		}
+	}
+}
+
+// Profile returns the HealthPlanet profile of the readings, if any.
+func (m AggregatedInnerScanDataMap) Profile() *InnerScanProfile {
+	for _, d := range m {
+		if d.Profile != nil {
+			return d.Profile
+		}
+	}
+	return nil
+}
+
+// heightToleranceCm is the largest difference between the HealthPlanet and
+// Fitbit heights that is not corrected.
+const heightToleranceCm = 0.5
+
+const cmPerInch = 2.54
+
+// SyncHeight sets the height in the Fitbit profile to heightCm unless it is
+// already within heightToleranceCm, and reports whether it was updated.
+// Fitbit uses inches for accounts in pounds (see FitbitAPI.do).
+func (api *FitbitAPI) SyncHeight(ctx context.Context, heightCm float64) (bool, error) {
+	profile, err := api.GetProfile(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	toCm, fromCm := func(v float64) float64 { return v }, func(v float64) float64 { return v }
+	if api.WeightUnit == WeightUnitLb {
+		toCm = func(v float64) float64 { return v * cmPerInch }
+		fromCm = func(v float64) float64 { return v / cmPerInch }
+	}
+	if math.Abs(toCm(profile.User.Height)-heightCm) <= heightToleranceCm {
+		return false, nil
+	}
+
+	height := strconv.FormatFloat(math.Round(fromCm(heightCm)*10)/10, 'f', 1, 64)
+	res, err := api.do(ctx, "POST", fmt.Sprintf("https://api.fitbit.com/1/user/-/profile.json?height=%s", height))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to update profile in fitbit")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		return false, fitbitStatusError(res, "update profile")
+	}
+
+	return true, nil
+}
@@ -0,0 +1,103 @@
+package htf
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApplyDerived(t *testing.T) {
+	weight, fat := 72.25, 20.0
+	t1 := time.Date(2023, 1, 1, 7, 0, 0, 0, tz)
+	t2 := t1.AddDate(0, 0, 1)
+	profile := &InnerScanProfile{Height: "170.0"}
+	data := AggregatedInnerScanDataMap{
+		t1: {Weight: &weight, Fat: &fat, Profile: profile},
+		t2: {Weight: &weight},
+	}
+
+	data.ApplyDerived()
+
+	d := data[t1]
+	if d.BMI == nil || *d.BMI != 25 {
+		t.Errorf("BMI = %v, want 25", d.BMI)
+	}
+	if d.FatMass == nil || *d.FatMass != 14.45 {
+		t.Errorf("FatMass = %v, want 14.45", d.FatMass)
+	}
+	if d.LeanMass == nil || *d.LeanMass != 57.8 {
+		t.Errorf("LeanMass = %v, want 57.8", d.LeanMass)
+	}
+	if d := data[t2]; d.BMI != nil || d.FatMass != nil || d.LeanMass != nil {
+		t.Errorf("reading without height and fat = %+v", d)
+	}
+
+	records := ExportRecords(data)
+	var tags []string
+	for _, r := range records {
+		tags = append(tags, r.Tag)
+	}
+	if len(tags) != 6 || tags[2] != ExportTagBMI || tags[3] != ExportTagFatMass || tags[4] != ExportTagLeanMass {
+		t.Errorf("ExportRecords() tags = %v", tags)
+	}
+}
+
+func TestFileSinkDerivedCSV(t *testing.T) {
+	sink := &FileSink{Path: filepath.Join(t.TempDir(), "ledger.csv"), Format: FileFormatCSV}
+	ctx := context.Background()
+	ts := time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC)
+	weight, bmi := 72.25, 25.0
+
+	if err := sink.WriteMeasurement(ctx, Measurement{Time: ts, Weight: &weight, BMI: &bmi}); err != nil {
+		t.Fatalf("WriteMeasurement() error = %v", err)
+	}
+	ms, err := sink.ListMeasurements(ctx, ts, ts)
+	if err != nil {
+		t.Fatalf("ListMeasurements() error = %v", err)
+	}
+	if len(ms) != 1 || ms[0].BMI == nil || *ms[0].BMI != 25 || ms[0].FatMass != nil {
+		t.Errorf("ListMeasurements() = %+v", ms)
+	}
+}
+
+func TestSyncHeight(t *testing.T) {
+	tests := []struct {
+		name       string
+		unit       string
+		profile    string
+		wantUpdate string
+	}{
+		{"same", WeightUnitKg, `{"user":{"height":170.2}}`, ""},
+		{"metric", WeightUnitKg, `{"user":{"height":165}}`, "170.0"},
+		{"inches", WeightUnitLb, `{"user":{"height":65}}`, "66.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var update string
+			api := &FitbitAPI{WeightUnit: tt.unit, Client: NewTestClient(func(req *http.Request) *http.Response {
+				body := tt.profile
+				if req.Method == "POST" {
+					update = req.URL.Query().Get("height")
+					body = `{}`
+				}
+				return &http.Response{
+					StatusCode: 200,
+					Body:       io.NopCloser(bytes.NewBufferString(body)),
+					Header:     make(http.Header),
+				}
+			})}
+
+			updated, err := api.SyncHeight(context.Background(), 170)
+			if err != nil {
+				t.Fatalf("SyncHeight() error = %v", err)
+			}
+			if updated != (tt.wantUpdate != "") || update != tt.wantUpdate {
+				t.Errorf("SyncHeight() = %v, height = %q, want %q", updated, update, tt.wantUpdate)
+			}
+		})
+	}
+}
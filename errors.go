@@ -0,0 +1,176 @@
+package htf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Errors matched by an APIError with errors.Is, by the status code and the
+// error body of the response.
+var (
+	// ErrRateLimited is a 429 response. RateLimitReset tells when to retry.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUnauthorized is a 401 response, or a 403 response that is not
+	// about scopes. The token must be authorized again.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrTokenExpired is a 401 response for an expired access token. It
+	// also matches ErrUnauthorized.
+	ErrTokenExpired = errors.New("token expired")
+	// ErrInsufficientScope is a 403 response for a token lacking a scope.
+	ErrInsufficientScope = errors.New("insufficient scope")
+	// ErrBadRequest is any other 4xx response: the request itself is wrong
+	// and sending it again fails the same way. APIError.Errors has the
+	// reasons given by the provider.
+	ErrBadRequest = errors.New("bad request")
+	// ErrUpstream is a 5xx response.
+	ErrUpstream = errors.New("upstream error")
+)
+
+// APIError is returned when a provider responds with an unexpected status code.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+	// Errors are the errors listed in the response body, if the provider
+	// returns them in the Fitbit format.
+	Errors []ProviderError
+	// Reset is when the rate limit resets, for 429 responses that tell.
+	Reset time.Time
+	msg   string
+}
+
+// ProviderError is one entry of the errors list of a Fitbit error response.
+type ProviderError struct {
+	Type    string `json:"errorType"`
+	Field   string `json:"fieldName"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return e.msg
+}
+
+// Is matches e with the Err variables above.
+func (e *APIError) Is(target error) bool {
+	status := e.StatusCode
+	switch target {
+	case ErrRateLimited:
+		return status == http.StatusTooManyRequests
+	case ErrTokenExpired:
+		return status == http.StatusUnauthorized && e.hasType("expired_token")
+	case ErrUnauthorized:
+		return status == http.StatusUnauthorized || (status == http.StatusForbidden && !e.isScopeError())
+	case ErrInsufficientScope:
+		return status == http.StatusForbidden && e.isScopeError()
+	case ErrBadRequest:
+		switch status {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+			return false
+		}
+		return 400 <= status && status < 500
+	case ErrUpstream:
+		return status >= 500
+	}
+	return false
+}
+
+func (e *APIError) hasType(types ...string) bool {
+	for _, pe := range e.Errors {
+		if slices.Contains(types, pe.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *APIError) isScopeError() bool {
+	return e.hasType("insufficient_scope", "insufficient_permissions")
+}
+
+// StatusCode returns the HTTP status code of an APIError in err's chain, or 0.
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// RateLimitReset returns when the rate limit behind err resets, or the zero
+// time if err is not ErrRateLimited or the provider did not tell.
+func RateLimitReset(err error) time.Time {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && errors.Is(apiErr, ErrRateLimited) {
+		return apiErr.Reset
+	}
+	return time.Time{}
+}
+
+// newStatusError builds the APIError for a non-2xx response, reading its
+// body.
+func newStatusError(provider string, res *http.Response) *APIError {
+	bodyBytes, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	e := &APIError{
+		Provider:   provider,
+		StatusCode: res.StatusCode,
+		Body:       string(bodyBytes),
+	}
+
+	var body struct {
+		Errors []ProviderError `json:"errors"`
+	}
+	if json.Unmarshal(bodyBytes, &body) == nil {
+		e.Errors = body.Errors
+	}
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		now := time.Now()
+		// Fitbit tells the seconds until the hourly limit resets
+		if s, err := strconv.Atoi(res.Header.Get("Fitbit-Rate-Limit-Reset")); err == nil {
+			e.Reset = now.Add(time.Duration(s) * time.Second)
+		} else if d, ok := retryAfter(res.Header.Get("Retry-After"), now); ok {
+			e.Reset = now.Add(d)
+		}
+	}
+	return e
+}
+
+// fitbitStatusError builds the error for a non-2xx Fitbit response.
+// action describes the call, e.g. "create weight log".
+func fitbitStatusError(res *http.Response, action string) error {
+	e := newStatusError("fitbit", res)
+	if errors.Is(e, ErrRateLimited) {
+		e.msg = fmt.Sprintf("failed to %s in fitbit: rate limited (Status: 429)", action)
+		if !e.Reset.IsZero() {
+			e.msg += fmt.Sprintf(", resets at %s", e.Reset.Format(time.RFC3339))
+		}
+		return e
+	}
+
+	e.msg = fmt.Sprintf("failed to %s in fitbit(invalid status code): %d", action, res.StatusCode)
+	// The messages may quote the token, so only the types are shown
+	var types []string
+	for _, pe := range e.Errors {
+		types = append(types, pe.Type)
+	}
+	if len(types) > 0 {
+		e.msg += " " + strings.Join(types, ", ")
+	}
+	return e
+}
+
+// healthPlanetStatusError builds the error for a non-2xx HealthPlanet
+// response. name describes the data, e.g. "inner scan".
+func healthPlanetStatusError(res *http.Response, name string) error {
+	e := newStatusError("healthplanet", res)
+	e.msg = fmt.Sprintf("failed to get %s(invalid status code): %d, body: %s", name, res.StatusCode, e.Body)
+	return e
+}
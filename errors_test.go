@@ -0,0 +1,90 @@
+package htf
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func statusClient(status int, header http.Header, body string) *http.Client {
+	return NewTestClient(func(req *http.Request) *http.Response {
+		if header == nil {
+			header = make(http.Header)
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       io.NopCloser(bytes.NewBufferString(body)),
+			Header:     header,
+		}
+	})
+}
+
+func TestAPIError_Is(t *testing.T) {
+	allKinds := []error{ErrRateLimited, ErrUnauthorized, ErrTokenExpired, ErrInsufficientScope, ErrBadRequest, ErrUpstream}
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   []error
+	}{
+		{"rate limited", 429, ``, []error{ErrRateLimited}},
+		{"expired token", 401, `{"errors":[{"errorType":"expired_token","message":"Access token expired: abc"}],"success":false}`, []error{ErrUnauthorized, ErrTokenExpired}},
+		{"invalid token", 401, `{"errors":[{"errorType":"invalid_token"}]}`, []error{ErrUnauthorized}},
+		{"insufficient scope", 403, `{"errors":[{"errorType":"insufficient_scope"}]}`, []error{ErrInsufficientScope}},
+		{"forbidden", 403, ``, []error{ErrUnauthorized}},
+		{"validation", 400, `{"errors":[{"errorType":"validation","fieldName":"weight","message":"Invalid weight"}]}`, []error{ErrBadRequest}},
+		{"not found", 404, ``, []error{ErrBadRequest}},
+		{"server error", 502, `<html>`, []error{ErrUpstream}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &FitbitAPI{Client: statusClient(tt.status, nil, tt.body)}
+			_, err := api.CreateWeightLog(context.Background(), 70, time.Now())
+			for _, kind := range allKinds {
+				want := false
+				for _, w := range tt.want {
+					want = want || w == kind
+				}
+				if got := errors.Is(err, kind); got != want {
+					t.Errorf("errors.Is(%v, %v) = %v, want %v", err, kind, got, want)
+				}
+			}
+			if strings.Contains(err.Error(), "abc") {
+				t.Errorf("error = %q quotes the provider message", err)
+			}
+		})
+	}
+}
+
+func TestAPIError_Details(t *testing.T) {
+	header := make(http.Header)
+	header.Set("Fitbit-Rate-Limit-Reset", "600")
+	api := &FitbitAPI{Client: statusClient(429, header, ``)}
+	before := time.Now()
+	_, err := api.GetBodyWeightLog(context.Background(), before)
+	if reset := RateLimitReset(err); reset.Before(before.Add(600*time.Second)) || reset.After(time.Now().Add(600*time.Second)) {
+		t.Errorf("RateLimitReset() = %v, want 10 minutes from now", reset)
+	}
+
+	api = &FitbitAPI{Client: statusClient(400, nil, `{"errors":[{"errorType":"validation","fieldName":"fat","message":"Invalid fat"}]}`)}
+	_, err = api.CreateBodyFatLog(context.Background(), 120, time.Now())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || len(apiErr.Errors) != 1 || apiErr.Errors[0] != (ProviderError{Type: "validation", Field: "fat", Message: "Invalid fat"}) {
+		t.Errorf("error = %#v", err)
+	}
+	if !RateLimitReset(err).IsZero() {
+		t.Errorf("RateLimitReset() = %v for a bad request", RateLimitReset(err))
+	}
+
+	hp := &HealthPlanetAPI{Client: statusClient(401, nil, `Unauthorized`)}
+	_, err = hp.GetInnerScan(context.Background(), InnerScanTagWeight, "", "")
+	if !errors.Is(err, ErrUnauthorized) || StatusCode(err) != 401 {
+		t.Errorf("GetInnerScan() error = %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package htf
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ExportFormatCSV       = "csv"
+	ExportFormatJSONLines = "jsonl"
+	ExportFormatJSON      = "json"
+)
+
+// Tags of the values derived from the readings (see ApplyDerived).
+const (
+	ExportTagBMI      = "bmi"
+	ExportTagFatMass  = "fat_mass"
+	ExportTagLeanMass = "lean_mass"
+)
+
+// ExportRecord is a single InnerScan reading (one tag at one point in time).
+type ExportRecord struct {
+	Model     string  `json:"model"`
+	Tag       string  `json:"tag"`
+	Value     float64 `json:"value"`
+	Unit      string  `json:"unit"`
+	LocalTime string  `json:"local_time"`
+	UTCTime   string  `json:"utc_time"`
+	Sex       string  `json:"sex"`
+	Height    string  `json:"height"`
+	BirthDate string  `json:"birth_date"`
+	// Trend is the smoothed weight, set on weight records when computed.
+	Trend *float64 `json:"trend,omitempty"`
+}
+
+var exportCSVHeader = []string{"model", "tag", "value", "unit", "local_time", "utc_time", "sex", "height", "birth_date", "trend"}
+
+// ExportRecords flattens the aggregated data into one record per tag,
+// ordered by time.
+func ExportRecords(data AggregatedInnerScanDataMap) []ExportRecord {
+	times := data.Times()
+
+	var records []ExportRecord
+	for _, t := range times {
+		d := data[t]
+		base := ExportRecord{
+			Model:     d.Model,
+			LocalTime: t.In(tz).Format(time.RFC3339),
+			UTCTime:   t.UTC().Format(time.RFC3339),
+		}
+		if d.Profile != nil {
+			base.Sex = d.Profile.Sex
+			base.Height = d.Profile.Height
+			base.BirthDate = d.Profile.BirthDate
+		}
+
+		if d.Weight != nil {
+			r := base
+			r.Tag = strconv.Itoa(int(InnerScanTagWeight))
+			r.Value = *d.Weight
+			r.Unit = WeightUnitKg
+			r.Trend = d.WeightTrend
+			records = append(records, r)
+		}
+		if d.Fat != nil {
+			r := base
+			r.Tag = strconv.Itoa(int(InnerScanTagBodyFatPct))
+			r.Value = *d.Fat
+			r.Unit = "%"
+			records = append(records, r)
+		}
+		for _, derived := range []struct {
+			tag   string
+			value *float64
+			unit  string
+		}{
+			{ExportTagBMI, d.BMI, "kg/m2"},
+			{ExportTagFatMass, d.FatMass, WeightUnitKg},
+			{ExportTagLeanMass, d.LeanMass, WeightUnitKg},
+		} {
+			if derived.value != nil {
+				r := base
+				r.Tag = derived.tag
+				r.Value = *derived.value
+				r.Unit = derived.unit
+				records = append(records, r)
+			}
+		}
+	}
+
+	return records
+}
+
+// ConvertExportUnit converts the weight records (and their trend) to unit,
+// rounded with r.
+func ConvertExportUnit(records []ExportRecord, unit string, r Rounding) []ExportRecord {
+	converted := make([]ExportRecord, len(records))
+	for i, rec := range records {
+		if rec.Unit == WeightUnitKg {
+			rec.Value = r.Round(ConvertWeight(rec.Value, unit))
+			if rec.Trend != nil {
+				trend := r.Round(ConvertWeight(*rec.Trend, unit))
+				rec.Trend = &trend
+			}
+			rec.Unit = unit
+		}
+		converted[i] = rec
+	}
+	return converted
+}
+
+// WriteExport writes the records to w in the given format.
+func WriteExport(w io.Writer, format string, records []ExportRecord) error {
+	switch format {
+	case ExportFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(exportCSVHeader); err != nil {
+			return errors.Wrap(err, "failed to write csv header")
+		}
+		for _, r := range records {
+			row := []string{
+				r.Model,
+				r.Tag,
+				strconv.FormatFloat(r.Value, 'f', -1, 64),
+				r.Unit,
+				r.LocalTime,
+				r.UTCTime,
+				r.Sex,
+				r.Height,
+				r.BirthDate,
+				formatOptionalFloat(r.Trend),
+			}
+			if err := cw.Write(row); err != nil {
+				return errors.Wrap(err, "failed to write csv row")
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case ExportFormatJSONLines:
+		enc := json.NewEncoder(w)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return errors.Wrap(err, "failed to write json line")
+			}
+		}
+		return nil
+	case ExportFormatJSON:
+		if records == nil {
+			records = []ExportRecord{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	default:
+		return errors.Errorf("unknown export format: %s", format)
+	}
+}
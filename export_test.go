@@ -0,0 +1,86 @@
+package htf
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportRecords(t *testing.T) {
+	t1 := time.Date(2023, 1, 1, 12, 0, 0, 0, tz).UTC()
+	t2 := time.Date(2023, 1, 2, 12, 0, 0, 0, tz).UTC()
+	weight1, fat1, weight2 := 70.5, 20.5, 70.1
+	profile := &InnerScanProfile{BirthDate: "19900101", Height: "170", Sex: "male"}
+	data := AggregatedInnerScanDataMap{
+		t2: {Weight: &weight2, Model: "01000117", Profile: profile},
+		t1: {Weight: &weight1, Fat: &fat1, Model: "01000117", Profile: profile},
+	}
+
+	records := ExportRecords(data)
+	if len(records) != 3 {
+		t.Fatalf("ExportRecords() got %d records, want 3", len(records))
+	}
+
+	want := ExportRecord{
+		Model:     "01000117",
+		Tag:       "6021",
+		Value:     70.5,
+		Unit:      "kg",
+		LocalTime: "2023-01-01T12:00:00+09:00",
+		UTCTime:   "2023-01-01T03:00:00Z",
+		Sex:       "male",
+		Height:    "170",
+		BirthDate: "19900101",
+	}
+	if records[0] != want {
+		t.Errorf("records[0] = %+v, want %+v", records[0], want)
+	}
+	if records[1].Tag != "6022" || records[1].Value != 20.5 {
+		t.Errorf("records[1] = %+v", records[1])
+	}
+	if records[2].UTCTime != "2023-01-02T03:00:00Z" {
+		t.Errorf("records[2] = %+v", records[2])
+	}
+}
+
+func TestWriteExport(t *testing.T) {
+	records := []ExportRecord{
+		{Model: "m", Tag: "6021", Value: 70.5, Unit: "kg", LocalTime: "2023-01-01T12:00:00+09:00", UTCTime: "2023-01-01T03:00:00Z", Sex: "male", Height: "170", BirthDate: "19900101"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteExport(&buf, ExportFormatCSV, records); err != nil {
+		t.Fatalf("WriteExport(csv) error = %v", err)
+	}
+	want := "model,tag,value,unit,local_time,utc_time,sex,height,birth_date,trend\n" +
+		"m,6021,70.5,kg,2023-01-01T12:00:00+09:00,2023-01-01T03:00:00Z,male,170,19900101,\n"
+	if buf.String() != want {
+		t.Errorf("WriteExport(csv) = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := WriteExport(&buf, ExportFormatJSONLines, records); err != nil {
+		t.Fatalf("WriteExport(jsonl) error = %v", err)
+	}
+	var got ExportRecord
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid json line: %v", err)
+	}
+	if got != records[0] {
+		t.Errorf("WriteExport(jsonl) = %+v, want %+v", got, records[0])
+	}
+
+	buf.Reset()
+	if err := WriteExport(&buf, ExportFormatJSON, nil); err != nil {
+		t.Fatalf("WriteExport(json) error = %v", err)
+	}
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("WriteExport(json) = %q, want []", buf.String())
+	}
+
+	if err := WriteExport(&buf, "xml", records); err == nil {
+		t.Error("WriteExport(xml) error = nil, want error")
+	}
+}
@@ -0,0 +1,262 @@
+package htf
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	FileFormatJSONLines = "jsonl"
+	FileFormatCSV       = "csv"
+)
+
+var fileSinkCSVHeader = append([]string{"time"}, measurementFieldNames...)
+
+// FileSink stores measurements in a local JSON Lines or CSV file.
+type FileSink struct {
+	Path string
+	// Format is either FileFormatJSONLines (default) or FileFormatCSV.
+	Format string
+
+	mu sync.Mutex
+}
+
+func (s *FileSink) Name() string {
+	return "file:" + s.Path
+}
+
+func (s *FileSink) WriteMeasurement(ctx context.Context, m Measurement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return errors.Wrap(err, "failed to create directory")
+	}
+
+	_, statErr := os.Stat(s.Path)
+	isNew := os.IsNotExist(statErr)
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+
+	if err := s.encode(f, []Measurement{m}, isNew); err != nil {
+		return errors.Wrap(err, "failed to write measurement")
+	}
+
+	return nil
+}
+
+func (s *FileSink) ListMeasurements(ctx context.Context, from, to time.Time) ([]Measurement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var ms []Measurement
+	for _, m := range all {
+		if m.Time.Before(from) || m.Time.After(to) {
+			continue
+		}
+		ms = append(ms, m)
+	}
+
+	return ms, nil
+}
+
+func (s *FileSink) DeleteMeasurement(ctx context.Context, m Measurement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.readAll()
+	if err != nil {
+		return err
+	}
+
+	kept := all[:0]
+	for _, e := range all {
+		if !e.Time.Equal(m.Time) {
+			kept = append(kept, e)
+		}
+	}
+
+	return s.writeAll(kept)
+}
+
+// UpdateMeasurements replaces the stored measurements at the times of ms,
+// rewriting the file once. Measurements the file does not hold are ignored.
+func (s *FileSink) UpdateMeasurements(ctx context.Context, ms []Measurement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.readAll()
+	if err != nil {
+		return err
+	}
+
+	updates := make(map[int64]Measurement, len(ms))
+	for _, m := range ms {
+		updates[m.Time.Unix()] = m
+	}
+	for i, e := range all {
+		if m, ok := updates[e.Time.Unix()]; ok {
+			all[i] = m
+		}
+	}
+
+	return s.writeAll(all)
+}
+
+func (s *FileSink) writeAll(ms []Measurement) error {
+	tmp := s.Path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	if err := s.encode(f, ms, true); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write measurements")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close file")
+	}
+
+	return os.Rename(tmp, s.Path)
+}
+
+func (s *FileSink) readAll() ([]Measurement, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+
+	if s.Format == FileFormatCSV {
+		return decodeMeasurementsCSV(f)
+	}
+	return decodeMeasurementsJSONLines(f)
+}
+
+func (s *FileSink) encode(w io.Writer, ms []Measurement, header bool) error {
+	if s.Format == FileFormatCSV {
+		return encodeMeasurementsCSV(w, ms, header)
+	}
+	return encodeMeasurementsJSONLines(w, ms)
+}
+
+func encodeMeasurementsJSONLines(w io.Writer, ms []Measurement) error {
+	enc := json.NewEncoder(w)
+	for _, m := range ms {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeMeasurementsJSONLines(r io.Reader) ([]Measurement, error) {
+	var ms []Measurement
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var m Measurement
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			return nil, errors.Wrap(err, "failed to parse measurement")
+		}
+		ms = append(ms, m)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read file")
+	}
+	return ms, nil
+}
+
+func encodeMeasurementsCSV(w io.Writer, ms []Measurement, header bool) error {
+	cw := csv.NewWriter(w)
+	if header {
+		if err := cw.Write(fileSinkCSVHeader); err != nil {
+			return err
+		}
+	}
+	for _, m := range ms {
+		row := []string{m.Time.UTC().Format(time.RFC3339)}
+		for _, f := range m.fields() {
+			row = append(row, formatOptionalFloat(*f))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func decodeMeasurementsCSV(r io.Reader) ([]Measurement, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read csv")
+	}
+
+	var ms []Measurement
+	for i, rec := range records {
+		// Columns after fat were added later and may be missing
+		if i == 0 || len(rec) < 3 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, rec[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid time")
+		}
+		m := Measurement{Time: t}
+		for j, f := range m.fields() {
+			if j+1 >= len(rec) {
+				break
+			}
+			if *f, err = parseOptionalFloat(rec[j+1]); err != nil {
+				return nil, errors.Wrapf(err, "invalid %s", fileSinkCSVHeader[j+1])
+			}
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+func formatOptionalFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+func parseOptionalFloat(s string) (*float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
@@ -0,0 +1,68 @@
+package htf
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSink(t *testing.T) {
+	for _, format := range []string{FileFormatJSONLines, FileFormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			sink := &FileSink{
+				Path:   filepath.Join(t.TempDir(), "measurements."+format),
+				Format: format,
+			}
+			ctx := context.Background()
+
+			t1 := time.Date(2023, 1, 1, 12, 0, 0, 0, tz).UTC()
+			t2 := time.Date(2023, 1, 2, 12, 0, 0, 0, tz).UTC()
+			weight := 70.5
+			fat := 20.5
+			data := AggregatedInnerScanDataMap{
+				t1: {Weight: &weight, Fat: &fat},
+				t2: {Weight: &weight},
+			}
+
+			created, err := SyncSink(ctx, sink, data)
+			if err != nil {
+				t.Fatalf("SyncSink() error = %v", err)
+			}
+			if created != 2 {
+				t.Errorf("SyncSink() created = %d, want 2", created)
+			}
+
+			// Second run must not write duplicates
+			created, err = SyncSink(ctx, sink, data)
+			if err != nil {
+				t.Fatalf("SyncSink() error = %v", err)
+			}
+			if created != 0 {
+				t.Errorf("SyncSink() created = %d, want 0", created)
+			}
+
+			ms, err := sink.ListMeasurements(ctx, t1, t1)
+			if err != nil {
+				t.Fatalf("ListMeasurements() error = %v", err)
+			}
+			if len(ms) != 1 {
+				t.Fatalf("ListMeasurements() got %d items, want 1", len(ms))
+			}
+			if !ms[0].Time.Equal(t1) || *ms[0].Weight != 70.5 || *ms[0].Fat != 20.5 {
+				t.Errorf("ListMeasurements() = %+v", ms[0])
+			}
+
+			if err := sink.DeleteMeasurement(ctx, ms[0]); err != nil {
+				t.Fatalf("DeleteMeasurement() error = %v", err)
+			}
+			ms, err = sink.ListMeasurements(ctx, t1, t2)
+			if err != nil {
+				t.Fatalf("ListMeasurements() error = %v", err)
+			}
+			if len(ms) != 1 || !ms[0].Time.Equal(t2) || ms[0].Fat != nil {
+				t.Errorf("ListMeasurements() after delete = %+v", ms)
+			}
+		})
+	}
+}
@@ -29,7 +29,7 @@ func GetFitbitConfig(clientID string, clientSecret string) *oauth2.Config {
 	return &oauth2.Config{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
-		Scopes:       []string{"weight"},
+		Scopes:       []string{"weight", "profile", "activity"},
 		Endpoint: oauth2.Endpoint{
 			AuthURL:  "https://www.fitbit.com/oauth2/authorize",
 			TokenURL: "https://api.fitbit.com/oauth2/token",
@@ -41,76 +41,127 @@ func GetFitbitConfig(clientID string, clientSecret string) *oauth2.Config {
 type FitbitAPI struct {
 	Client      *http.Client
 	TokenSource oauth2.TokenSource
+	// WeightUnit is the unit weights are written and read in. Defaults to kg.
+	WeightUnit string
+	// Rounding is applied to values before they are written. Defaults to
+	// DefaultRounding.
+	Rounding *Rounding
 }
 
-func NewFitbitAPI(clientID string, clientSecret string, token *oauth2.Token) *FitbitAPI {
+// NewFitbitAPI returns a client authorized with token. The token is refreshed
+// with ctx, so cancelling ctx also stops a pending refresh. An
+// oauth2.HTTPClient in ctx is used for the refresh.
+//
+// If save is not nil, it is called with every refreshed token before the
+// token is used (see PersistingTokenSource).
+func NewFitbitAPI(ctx context.Context, clientID string, clientSecret string, token *oauth2.Token, save func(token *oauth2.Token) error) *FitbitAPI {
 	cfg := GetFitbitConfig(clientID, clientSecret)
-	tokenSource := cfg.TokenSource(context.Background(), token)
-	cli := oauth2.NewClient(context.Background(), tokenSource)
+	var tokenSource oauth2.TokenSource = cfg.TokenSource(ctx, token)
+	if save != nil {
+		tokenSource = NewPersistingTokenSource(tokenSource, token, save)
+	}
+	cli := oauth2.NewClient(ctx, tokenSource)
 	return &FitbitAPI{
 		Client:      cli,
 		TokenSource: tokenSource,
 	}
 }
 
-func (api *FitbitAPI) CreateWeightLog(weight float64, date time.Time) error {
+// do sends a request without body. Fitbit reads and returns weights in the
+// unit system selected by the Accept-Language header, metric if it is absent.
+func (api *FitbitAPI) do(ctx context.Context, method, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if api.WeightUnit == WeightUnitLb {
+		req.Header.Set("Accept-Language", "en_US")
+	}
+	// Token refresh errors quote the refresh token
+	res, err := api.Client.Do(req)
+	return res, RedactError(err)
+}
+
+func (api *FitbitAPI) rounding() Rounding {
+	if api.Rounding == nil {
+		return DefaultRounding
+	}
+	return *api.Rounding
+}
+
+// createBodyLogResponse is the part of the response to a created weight or
+// fat log that identifies it.
+type createBodyLogResponse struct {
+	WeightLog struct {
+		LogId int64 `json:"logId"`
+	} `json:"weightLog"`
+	FatLog struct {
+		LogId int64 `json:"logId"`
+	} `json:"fatLog"`
+}
+
+// CreateWeightLog logs weight, given in api.WeightUnit, and returns the id of
+// the log.
+func (api *FitbitAPI) CreateWeightLog(ctx context.Context, weight float64, date time.Time) (int64, error) {
 	values := url.Values{}
 	values.Add("weight", strconv.FormatFloat(weight, 'f', 2, 64))
 	values.Add("date", date.Format("2006-01-02"))
 	values.Add("time", date.Format("15:04:05"))
 
-	res, err := api.Client.Post(fmt.Sprintf("https://api.fitbit.com/1/user/-/body/log/weight.json?%s", values.Encode()), "application/json", nil)
+	res, err := api.do(ctx, "POST", fmt.Sprintf("https://api.fitbit.com/1/user/-/body/log/weight.json?%s", values.Encode()))
 	if err != nil {
-		return errors.Wrap(err, "failed to create weight log in fitbit")
+		return 0, errors.Wrap(err, "failed to create weight log in fitbit")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode < 200 || 400 <= res.StatusCode {
-		if res.StatusCode == 429 {
-			return errors.New("Fitbit API limit exceeded (Status: 429). Limit is 150 requests/hour. Please try again later.")
-		}
-		return errors.Errorf("failed to create weight log in fitbit(invalid status code): %d", res.StatusCode)
+		return 0, fitbitStatusError(res, "create weight log")
 	}
 
-	return nil
+	var resData createBodyLogResponse
+	if err := json.NewDecoder(res.Body).Decode(&resData); err != nil {
+		return 0, errors.Wrap(err, "failed to parse created weight log in fitbit")
+	}
+
+	return resData.WeightLog.LogId, nil
 }
 
-func (api *FitbitAPI) CreateBodyFatLog(fat float64, date time.Time) error {
+// CreateBodyFatLog logs fat and returns the id of the log.
+func (api *FitbitAPI) CreateBodyFatLog(ctx context.Context, fat float64, date time.Time) (int64, error) {
 	values := url.Values{}
 	values.Add("fat", strconv.FormatFloat(fat, 'f', 2, 64))
 	values.Add("date", date.Format("2006-01-02"))
 	values.Add("time", date.Format("15:04:05"))
 
-	res, err := api.Client.Post(fmt.Sprintf("https://api.fitbit.com/1/user/-/body/log/fat.json?%s", values.Encode()), "application/json", nil)
+	res, err := api.do(ctx, "POST", fmt.Sprintf("https://api.fitbit.com/1/user/-/body/log/fat.json?%s", values.Encode()))
 	if err != nil {
-		return errors.Wrap(err, "failed to create fat log in fitbit")
+		return 0, errors.Wrap(err, "failed to create fat log in fitbit")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode < 200 || 400 <= res.StatusCode {
-		if res.StatusCode == 429 {
-			return errors.New("Fitbit API limit exceeded (Status: 429). Limit is 150 requests/hour. Please try again later.")
-		}
-		return errors.Errorf("failed to create fat log in fitbit(invalid status code): %d", res.StatusCode)
+		return 0, fitbitStatusError(res, "create fat log")
 	}
 
-	return nil
+	var resData createBodyLogResponse
+	if err := json.NewDecoder(res.Body).Decode(&resData); err != nil {
+		return 0, errors.Wrap(err, "failed to parse created fat log in fitbit")
+	}
+
+	return resData.FatLog.LogId, nil
 }
 
-func (api *FitbitAPI) GetBodyWeightLog(date time.Time) (*GetWeightLogResponse, error) {
+func (api *FitbitAPI) GetBodyWeightLog(ctx context.Context, date time.Time) (*GetWeightLogResponse, error) {
 	formattedDate := date.Format("2006-01-02")
 
-	res, err := api.Client.Get(fmt.Sprintf("https://api.fitbit.com/1/user/-/body/log/weight/date/%s.json", formattedDate))
+	res, err := api.do(ctx, "GET", fmt.Sprintf("https://api.fitbit.com/1/user/-/body/log/weight/date/%s.json", formattedDate))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get weight log in fitbit")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode < 200 || 400 <= res.StatusCode {
-		if res.StatusCode == 429 {
-			return nil, errors.New("Fitbit API limit exceeded (Status: 429). Limit is 150 requests/hour. Please try again later.")
-		}
-		return nil, errors.Errorf("failed to get weight log in fitbit(invalid status code): %d", res.StatusCode)
+		return nil, fitbitStatusError(res, "get weight log")
 	}
 
 	dec := json.NewDecoder(res.Body)
@@ -121,3 +172,232 @@ func (api *FitbitAPI) GetBodyWeightLog(date time.Time) (*GetWeightLogResponse, e
 
 	return &resData, nil
 }
+
+type GetFatLogResponse struct {
+	Fat []struct {
+		Date   string  `json:"date"`
+		Fat    float64 `json:"fat"`
+		LogId  int64   `json:"logId"`
+		Source string  `json:"source"`
+		Time   string  `json:"time"`
+	} `json:"fat"`
+}
+
+// GetBodyWeightLogRange returns the weight logs between from and to (inclusive).
+// Fitbit allows at most 31 days per request.
+func (api *FitbitAPI) GetBodyWeightLogRange(ctx context.Context, from, to time.Time) (*GetWeightLogResponse, error) {
+	res, err := api.do(ctx, "GET", fmt.Sprintf("https://api.fitbit.com/1/user/-/body/log/weight/date/%s/%s.json", from.Format("2006-01-02"), to.Format("2006-01-02")))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get weight log in fitbit")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		return nil, fitbitStatusError(res, "get weight log")
+	}
+
+	dec := json.NewDecoder(res.Body)
+	var resData GetWeightLogResponse
+	if err := dec.Decode(&resData); err != nil {
+		return nil, errors.Wrap(err, "failed to parse weight log in fitbit")
+	}
+
+	return &resData, nil
+}
+
+// GetBodyFatLogRange returns the fat logs between from and to (inclusive).
+// Fitbit allows at most 31 days per request.
+func (api *FitbitAPI) GetBodyFatLogRange(ctx context.Context, from, to time.Time) (*GetFatLogResponse, error) {
+	res, err := api.do(ctx, "GET", fmt.Sprintf("https://api.fitbit.com/1/user/-/body/log/fat/date/%s/%s.json", from.Format("2006-01-02"), to.Format("2006-01-02")))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get fat log in fitbit")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		return nil, fitbitStatusError(res, "get fat log")
+	}
+
+	dec := json.NewDecoder(res.Body)
+	var resData GetFatLogResponse
+	if err := dec.Decode(&resData); err != nil {
+		return nil, errors.Wrap(err, "failed to parse fat log in fitbit")
+	}
+
+	return &resData, nil
+}
+
+func (api *FitbitAPI) GetBodyFatLog(ctx context.Context, date time.Time) (*GetFatLogResponse, error) {
+	res, err := api.do(ctx, "GET", fmt.Sprintf("https://api.fitbit.com/1/user/-/body/log/fat/date/%s.json", date.Format("2006-01-02")))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get fat log in fitbit")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		return nil, fitbitStatusError(res, "get fat log")
+	}
+
+	dec := json.NewDecoder(res.Body)
+	var resData GetFatLogResponse
+	if err := dec.Decode(&resData); err != nil {
+		return nil, errors.Wrap(err, "failed to parse fat log in fitbit")
+	}
+
+	return &resData, nil
+}
+
+func (api *FitbitAPI) DeleteWeightLog(ctx context.Context, logId int64) error {
+	res, err := api.do(ctx, http.MethodDelete, fmt.Sprintf("https://api.fitbit.com/1/user/-/body/log/weight/%d.json", logId))
+	if err != nil {
+		return errors.Wrap(err, "failed to delete weight log in fitbit")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		return fitbitStatusError(res, "delete weight log")
+	}
+
+	return nil
+}
+
+func (api *FitbitAPI) DeleteBodyFatLog(ctx context.Context, logId int64) error {
+	res, err := api.do(ctx, http.MethodDelete, fmt.Sprintf("https://api.fitbit.com/1/user/-/body/log/fat/%d.json", logId))
+	if err != nil {
+		return errors.Wrap(err, "failed to delete fat log in fitbit")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		return fitbitStatusError(res, "delete fat log")
+	}
+
+	return nil
+}
+
+func (api *FitbitAPI) Name() string {
+	return "fitbit"
+}
+
+func (api *FitbitAPI) WriteMeasurement(ctx context.Context, m Measurement) error {
+	for _, metric := range m.Metrics() {
+		if _, err := api.WriteMetric(ctx, metric, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteMetric implements MetricSink. The weight is converted to
+// api.WeightUnit, and both metrics are rounded.
+func (api *FitbitAPI) WriteMetric(ctx context.Context, metric string, m Measurement) (string, error) {
+	var logId int64
+	var err error
+	switch metric {
+	case MetricWeight:
+		weight := api.rounding().Round(ConvertWeight(*m.Weight, api.WeightUnit))
+		logId, err = api.CreateWeightLog(ctx, weight, m.Time)
+	case MetricFat:
+		logId, err = api.CreateBodyFatLog(ctx, api.rounding().Round(*m.Fat), m.Time)
+	default:
+		return "", errors.Errorf("unknown metric: %s", metric)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(logId, 10), nil
+}
+
+// DeleteMetric implements MetricSink.
+func (api *FitbitAPI) DeleteMetric(ctx context.Context, metric, id string) error {
+	logId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s log id", metric)
+	}
+	switch metric {
+	case MetricWeight:
+		return api.DeleteWeightLog(ctx, logId)
+	case MetricFat:
+		return api.DeleteBodyFatLog(ctx, logId)
+	default:
+		return errors.Errorf("unknown metric: %s", metric)
+	}
+}
+
+func (api *FitbitAPI) ListMeasurements(ctx context.Context, from, to time.Time) ([]Measurement, error) {
+	from, to = from.UTC(), to.UTC()
+	var ms []Measurement
+
+	// Iterate in 31-day chunks
+	for current := from; !current.After(to); current = current.AddDate(0, 0, 31) {
+		next := current.AddDate(0, 0, 30)
+		if next.After(to) {
+			next = to
+		}
+
+		weightLog, err := api.GetBodyWeightLogRange(ctx, current, next)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, w := range weightLog.Weight {
+			t, err := parseFitbitTime(w.Date, w.Time)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid weight log time")
+			}
+			if t.Before(from) || t.After(to) {
+				continue
+			}
+
+			weight := WeightToKg(w.Weight, api.WeightUnit)
+			m := Measurement{
+				Time:   t,
+				Weight: &weight,
+				ID:     strconv.FormatInt(w.LogId, 10),
+			}
+			if w.Fat != 0 {
+				fat := w.Fat
+				m.Fat = &fat
+			}
+			ms = append(ms, m)
+		}
+	}
+
+	return ms, nil
+}
+
+func (api *FitbitAPI) DeleteMeasurement(ctx context.Context, m Measurement) error {
+	if m.ID != "" {
+		logId, err := strconv.ParseInt(m.ID, 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "invalid weight log id")
+		}
+		if err := api.DeleteWeightLog(ctx, logId); err != nil {
+			return err
+		}
+	}
+
+	if m.Fat != nil {
+		fatLog, err := api.GetBodyFatLog(ctx, m.Time)
+		if err != nil {
+			return err
+		}
+		for _, f := range fatLog.Fat {
+			if f.Time != m.Time.Format("15:04:05") {
+				continue
+			}
+			if err := api.DeleteBodyFatLog(ctx, f.LogId); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseFitbitTime parses the date and time of a body log. Logs are created
+// with UTC date and time (see CreateWeightLog), so they are read back as UTC.
+func parseFitbitTime(date, clock string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04:05", date+" "+clock, time.UTC)
+}
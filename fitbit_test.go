@@ -1,6 +1,11 @@
 package htf
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"slices"
 	"testing"
 	"time"
 
@@ -18,8 +23,8 @@ func TestGetFitbitConfig(t *testing.T) {
 	if cfg.ClientSecret != clientSecret {
 		t.Errorf("ClientSecret = %v, want %v", cfg.ClientSecret, clientSecret)
 	}
-	if len(cfg.Scopes) != 1 || cfg.Scopes[0] != "weight" {
-		t.Errorf("Scopes = %v, want ['weight']", cfg.Scopes)
+	if want := []string{"weight", "profile", "activity"}; !slices.Equal(cfg.Scopes, want) {
+		t.Errorf("Scopes = %v, want %v", cfg.Scopes, want)
 	}
 	if cfg.Endpoint.AuthURL != "https://www.fitbit.com/oauth2/authorize" {
 		t.Errorf("AuthURL = %v", cfg.Endpoint.AuthURL)
@@ -38,7 +43,7 @@ func TestNewFitbitAPI(t *testing.T) {
 		Expiry:       time.Now().Add(time.Hour),
 	}
 
-	api := NewFitbitAPI(clientID, clientSecret, token)
+	api := NewFitbitAPI(context.Background(), clientID, clientSecret, token, nil)
 
 	if api == nil {
 		t.Fatal("NewFitbitAPI returned nil")
@@ -50,3 +55,63 @@ func TestNewFitbitAPI(t *testing.T) {
 		t.Error("api.TokenSource is nil")
 	}
 }
+
+type ctxKey struct{}
+
+func TestFitbitAPI_Context(t *testing.T) {
+	ctx := context.WithValue(context.Background(), ctxKey{}, "run")
+	var calls int
+	api := &FitbitAPI{Client: NewTestClient(func(req *http.Request) *http.Response {
+		calls++
+		if req.Context().Value(ctxKey{}) != "run" {
+			t.Errorf("%s %s was sent without the caller's context", req.Method, req.URL.Path)
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewBufferString(`{"weight": [], "fat": []}`)),
+			Header:     make(http.Header),
+		}
+	})}
+
+	if err := api.WriteMeasurement(ctx, Measurement{Time: time.Now(), Weight: new(float64), Fat: new(float64)}); err != nil {
+		t.Fatalf("WriteMeasurement() error = %v", err)
+	}
+	if _, err := api.ListMeasurements(ctx, time.Now().AddDate(0, 0, -1), time.Now()); err != nil {
+		t.Fatalf("ListMeasurements() error = %v", err)
+	}
+	if err := api.DeleteMeasurement(ctx, Measurement{Time: time.Now(), ID: "1", Fat: new(float64)}); err != nil {
+		t.Fatalf("DeleteMeasurement() error = %v", err)
+	}
+	if calls != 5 {
+		t.Errorf("calls = %d, want 5", calls)
+	}
+}
+
+func TestFitbitAPI_WriteMetric(t *testing.T) {
+	var deleted string
+	api := &FitbitAPI{Client: NewTestClient(func(req *http.Request) *http.Response {
+		body := `{"fatLog": {"logId": 42, "fat": 20.1}}`
+		if req.Method == http.MethodDelete {
+			deleted = req.URL.Path
+			body = ``
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})}
+	ctx := context.Background()
+	fat := 20.1
+
+	id, err := api.WriteMetric(ctx, MetricFat, Measurement{Time: time.Now(), Fat: &fat})
+	if err != nil || id != "42" {
+		t.Fatalf("WriteMetric() = %q, %v", id, err)
+	}
+	if err := api.DeleteMetric(ctx, MetricFat, id); err != nil {
+		t.Fatalf("DeleteMetric() error = %v", err)
+	}
+	if deleted != "/1/user/-/body/log/fat/42.json" {
+		t.Errorf("deleted = %s", deleted)
+	}
+}
@@ -0,0 +1,75 @@
+package htf
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"math/big"
+
+	"golang.org/x/oauth2"
+)
+
+// FitbitAuthFlow is the Fitbit OAuth2 authorization code flow with PKCE.
+type FitbitAuthFlow struct {
+	Config *oauth2.Config
+	// State is sent with the authorization request and must come back to
+	// the redirect URL (see ValidState), so that a callback forged by
+	// another site is refused.
+	State     string
+	verifier  string
+	challenge string
+}
+
+func NewFitbitAuthFlow(cfg *oauth2.Config) *FitbitAuthFlow {
+	verifier, challenge := genCodeChallenge()
+	return &FitbitAuthFlow{
+		Config:    cfg,
+		State:     randomString(32),
+		verifier:  verifier,
+		challenge: challenge,
+	}
+}
+
+// AuthCodeURL returns the URL the user has to open to authorize the app.
+func (f *FitbitAuthFlow) AuthCodeURL() string {
+	return f.Config.AuthCodeURL(f.State,
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("code_challenge", f.challenge),
+		oauth2.AccessTypeOffline,
+	)
+}
+
+// ValidState reports whether state, passed to the redirect URL, is the
+// state of this flow.
+func (f *FitbitAuthFlow) ValidState(state string) bool {
+	return state != "" && subtle.ConstantTimeCompare([]byte(state), []byte(f.State)) == 1
+}
+
+// Exchange converts the code passed to the redirect URL into a token.
+func (f *FitbitAuthFlow) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+	token, err := f.Config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", f.verifier))
+	return token, RedactError(err)
+}
+
+func randomString(n int) string {
+	var letter = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+
+	b := make([]rune, n)
+	for i := range b {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(len(letter))))
+		if err != nil {
+			panic(err)
+		}
+		b[i] = letter[j.Int64()]
+	}
+	return string(b)
+}
+
+func genCodeChallenge() (verifier string, challenge string) {
+	verifier = randomString(128)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge = base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(sum[:])
+	return
+}
@@ -0,0 +1,148 @@
+package htf
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	FitbitLogTypeWeight = "weight"
+	FitbitLogTypeFat    = "fat"
+)
+
+// FitbitBodyLog is a single weight or fat log entry stored in Fitbit.
+type FitbitBodyLog struct {
+	Type   string    `json:"type"`
+	LogId  int64     `json:"log_id"`
+	Time   time.Time `json:"time"`
+	Value  float64   `json:"value"`
+	Unit   string    `json:"unit"`
+	BMI    float64   `json:"bmi,omitempty"`
+	Fat    float64   `json:"fat,omitempty"`
+	Source string    `json:"source"`
+}
+
+var fitbitBodyLogCSVHeader = []string{"type", "log_id", "time", "value", "unit", "bmi", "fat", "source"}
+
+// metricValue returns the value in the unit HealthPlanet uses.
+func (l FitbitBodyLog) metricValue() float64 {
+	if l.Type == FitbitLogTypeWeight {
+		return WeightToKg(l.Value, l.Unit)
+	}
+	return l.Value
+}
+
+// ListBodyLogs returns all weight and fat logs between from and to, ordered by time.
+func (api *FitbitAPI) ListBodyLogs(ctx context.Context, from, to time.Time) ([]FitbitBodyLog, error) {
+	from, to = from.UTC(), to.UTC()
+	var logs []FitbitBodyLog
+
+	weightUnit := api.WeightUnit
+	if weightUnit == "" {
+		weightUnit = WeightUnitKg
+	}
+
+	// Iterate in 31-day chunks
+	for current := from; !current.After(to); current = current.AddDate(0, 0, 31) {
+		next := current.AddDate(0, 0, 30)
+		if next.After(to) {
+			next = to
+		}
+
+		weightLog, err := api.GetBodyWeightLogRange(ctx, current, next)
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range weightLog.Weight {
+			t, err := parseFitbitTime(w.Date, w.Time)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid weight log time")
+			}
+			logs = append(logs, FitbitBodyLog{
+				Type:   FitbitLogTypeWeight,
+				LogId:  w.LogId,
+				Time:   t,
+				Value:  w.Weight,
+				Unit:   weightUnit,
+				BMI:    w.BMI,
+				Fat:    w.Fat,
+				Source: w.Source,
+			})
+		}
+
+		fatLog, err := api.GetBodyFatLogRange(ctx, current, next)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fatLog.Fat {
+			t, err := parseFitbitTime(f.Date, f.Time)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid fat log time")
+			}
+			logs = append(logs, FitbitBodyLog{
+				Type:   FitbitLogTypeFat,
+				LogId:  f.LogId,
+				Time:   t,
+				Value:  f.Fat,
+				Unit:   "%",
+				Source: f.Source,
+			})
+		}
+	}
+
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].Time.Before(logs[j].Time) })
+
+	return logs, nil
+}
+
+// WriteBodyLogs writes the logs to w in one of the export formats.
+func WriteBodyLogs(w io.Writer, format string, logs []FitbitBodyLog) error {
+	switch format {
+	case ExportFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(fitbitBodyLogCSVHeader); err != nil {
+			return errors.Wrap(err, "failed to write csv header")
+		}
+		for _, l := range logs {
+			row := []string{
+				l.Type,
+				strconv.FormatInt(l.LogId, 10),
+				l.Time.Format(time.RFC3339),
+				strconv.FormatFloat(l.Value, 'f', -1, 64),
+				l.Unit,
+				strconv.FormatFloat(l.BMI, 'f', -1, 64),
+				strconv.FormatFloat(l.Fat, 'f', -1, 64),
+				l.Source,
+			}
+			if err := cw.Write(row); err != nil {
+				return errors.Wrap(err, "failed to write csv row")
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case ExportFormatJSONLines:
+		enc := json.NewEncoder(w)
+		for _, l := range logs {
+			if err := enc.Encode(l); err != nil {
+				return errors.Wrap(err, "failed to write json line")
+			}
+		}
+		return nil
+	case ExportFormatJSON:
+		if logs == nil {
+			logs = []FitbitBodyLog{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(logs)
+	default:
+		return errors.Errorf("unknown export format: %s", format)
+	}
+}
@@ -6,6 +6,7 @@ require (
 	github.com/joho/godotenv v1.4.0
 	github.com/pkg/errors v0.9.1
 	golang.org/x/oauth2 v0.0.0-20220722155238-128564f6959c
+	golang.org/x/text v0.21.0
 )
 
 require (
@@ -4,10 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
 	"strconv"
 	"time"
 
@@ -19,7 +20,8 @@ var tz *time.Location
 func init() {
 	t, err := time.LoadLocation("Asia/Tokyo")
 	if err != nil {
-		log.Fatalf("failed to load location: %v", err)
+		slog.Error("failed to load location", "error", err)
+		os.Exit(1)
 	}
 	tz = t
 }
@@ -39,12 +41,43 @@ type InnerScanData struct {
 }
 
 type AggregatedInnerScanData struct {
-	Weight *float64
-	Fat    *float64
+	Weight  *float64
+	Fat     *float64
+	Model   string
+	Profile *InnerScanProfile
+	// WeightTrend is the smoothed weight (see WeightTrend), if computed.
+	WeightTrend *float64
+	// BMI, FatMass and LeanMass are derived values (see ApplyDerived).
+	BMI      *float64
+	FatMass  *float64
+	LeanMass *float64
 }
 
 type AggregatedInnerScanDataMap map[time.Time]*AggregatedInnerScanData
 
+// Times returns the times of the readings in chronological order.
+func (m AggregatedInnerScanDataMap) Times() []time.Time {
+	times := make([]time.Time, 0, len(m))
+	for t := range m {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times
+}
+
+// Measurement converts the reading at t into a Measurement.
+func (d *AggregatedInnerScanData) Measurement(t time.Time) Measurement {
+	return Measurement{
+		Time:        t,
+		Weight:      d.Weight,
+		Fat:         d.Fat,
+		WeightTrend: d.WeightTrend,
+		BMI:         d.BMI,
+		FatMass:     d.FatMass,
+		LeanMass:    d.LeanMass,
+	}
+}
+
 func (d *InnerScanData) Time() (time.Time, error) {
 	layout := "200601021504"
 	t, err := time.ParseInLocation(layout, d.Date, tz)
@@ -55,146 +88,197 @@ func (d *InnerScanData) Time() (time.Time, error) {
 	return t.UTC(), nil
 }
 
+type InnerScanProfile struct {
+	BirthDate string `json:"birth_date"`
+	Height    string `json:"height"`
+	Sex       string `json:"sex"`
+}
+
 type InnerScanResponse struct {
-	BirthDate string          `json:"birth_date"`
-	Height    string          `json:"height"`
-	Sex       string          `json:"sex"`
-	Data      []InnerScanData `json:"data"`
+	InnerScanProfile
+	Data []InnerScanData `json:"data"`
+}
+
+// HealthPlanet date modes: whether from and to select readings by when they
+// were measured or when they were registered (uploaded) to HealthPlanet.
+const (
+	DateModeMeasurement  = "measurement"
+	DateModeRegistration = "registration"
+)
+
+// ParseDateMode validates a date mode. An empty mode is DateModeMeasurement.
+func ParseDateMode(s string) (string, error) {
+	switch s {
+	case "":
+		return DateModeMeasurement, nil
+	case DateModeMeasurement, DateModeRegistration:
+		return s, nil
+	default:
+		return "", errors.Errorf("unknown date mode: %s", s)
+	}
 }
 
 type HealthPlanetAPI struct {
 	AccessToken string
 	Client      *http.Client
+	// DateMode selects readings by measurement (default) or registration
+	// date. Step counts are always selected by measurement date, since the
+	// total of a day needs all of its readings.
+	DateMode string
 }
 
 func (api *HealthPlanetAPI) AggregateInnerScanData(ctx context.Context, from, to string) (AggregatedInnerScanDataMap, error) {
 	var weights InnerScanResponse
 	var fats InnerScanResponse
 
-	if from == "" {
-		// Default behavior (last 3 months)
-		var err error
-		weights, err = api.GetInnerScan(ctx, InnerScanTagWeight, "", "")
+	chunks, err := healthPlanetChunks(from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range chunks {
+		w, err := api.GetInnerScan(ctx, InnerScanTagWeight, c.from, c.to)
 		if err != nil {
 			return nil, err
 		}
-		fats, err = api.GetInnerScan(ctx, InnerScanTagBodyFatPct, "", "")
+		weights.InnerScanProfile = w.InnerScanProfile
+		weights.Data = append(weights.Data, w.Data...)
+
+		f, err := api.GetInnerScan(ctx, InnerScanTagBodyFatPct, c.from, c.to)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		// Parse dates
-		layout := "20060102150405"
-		startTime, err := time.Parse(layout, from)
-		if err != nil {
-			return nil, errors.Wrap(err, "invalid from date format")
-		}
-		endTime := time.Now()
-		if to != "" {
-			endTime, err = time.Parse(layout, to)
-			if err != nil {
-				return nil, errors.Wrap(err, "invalid to date format")
-			}
-		}
-
-		// Iterate in 3-month chunks
-		for current := startTime; current.Before(endTime); {
-			next := current.AddDate(0, 3, 0)
-			if next.After(endTime) {
-				next = endTime
-			}
-
-			chunkFrom := current.Format(layout)
-			chunkTo := next.Format(layout)
-
-			w, err := api.GetInnerScan(ctx, InnerScanTagWeight, chunkFrom, chunkTo)
-			if err != nil {
-				return nil, err
-			}
-			weights.Data = append(weights.Data, w.Data...)
-
-			f, err := api.GetInnerScan(ctx, InnerScanTagBodyFatPct, chunkFrom, chunkTo)
-			if err != nil {
-				return nil, err
-			}
-			fats.Data = append(fats.Data, f.Data...)
-
-			current = next.Add(time.Second) // Avoid overlap
-		}
+		fats.Data = append(fats.Data, f.Data...)
 	}
 
 	m := make(AggregatedInnerScanDataMap, len(weights.Data))
+	profile := weights.InnerScanProfile
 
 	for _, weight := range weights.Data {
 		t, err := weight.Time()
 		if err != nil {
-			log.Printf("invalid time: %+v", err)
+			slog.Warn("invalid time", "date", weight.Date, "metric", "weight", "error", err)
 			continue
 		}
 
 		data, err := strconv.ParseFloat(weight.KeyData, 64)
 		if err != nil {
-			log.Printf("invalid weight: %+v", err)
+			slog.Warn("invalid weight", "date", weight.Date, "metric", "weight", "value", weight.KeyData, "error", err)
 			continue
 		}
 
 		m[t] = &AggregatedInnerScanData{
-			Weight: &data,
+			Weight:  &data,
+			Model:   weight.Model,
+			Profile: &profile,
 		}
 	}
 
 	for _, fat := range fats.Data {
 		t, err := fat.Time()
 		if err != nil {
-			log.Printf("invalid time: %+v", err)
+			slog.Warn("invalid time", "date", fat.Date, "metric", "fat", "error", err)
 			continue
 		}
 
 		data, err := strconv.ParseFloat(fat.KeyData, 64)
 		if err != nil {
-			log.Printf("invalid fat: %+v", err)
+			slog.Warn("invalid fat", "date", fat.Date, "metric", "fat", "value", fat.KeyData, "error", err)
 			continue
 		}
 
 		if d, ok := m[t]; ok {
 			d.Fat = &data
 		} else {
-			log.Printf("weight data not found: %+v", fat)
+			slog.Warn("weight data not found", "timestamp", t, "metric", "fat", "value", fat.KeyData, "model", fat.Model)
 		}
 	}
 
 	return m, nil
 }
 
+type healthPlanetChunk struct {
+	from, to string
+}
+
+// healthPlanetChunks splits a date range (YYYYMMDDHHMMSS) into 3-month chunks,
+// the longest period the API serves at once. An empty from is a single chunk
+// with the API default (last 3 months).
+func healthPlanetChunks(from, to string) ([]healthPlanetChunk, error) {
+	if from == "" {
+		return []healthPlanetChunk{{}}, nil
+	}
+
+	// Parse dates
+	layout := "20060102150405"
+	startTime, err := time.Parse(layout, from)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid from date format")
+	}
+	endTime := time.Now()
+	if to != "" {
+		endTime, err = time.Parse(layout, to)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid to date format")
+		}
+	}
+
+	// Iterate in 3-month chunks
+	var chunks []healthPlanetChunk
+	for current := startTime; current.Before(endTime); {
+		next := current.AddDate(0, 3, 0)
+		if next.After(endTime) {
+			next = endTime
+		}
+		chunks = append(chunks, healthPlanetChunk{current.Format(layout), next.Format(layout)})
+		current = next.Add(time.Second) // Avoid overlap
+	}
+
+	return chunks, nil
+}
+
 func (api *HealthPlanetAPI) GetInnerScan(ctx context.Context, tag InnerScanTag, from, to string) (InnerScanResponse, error) {
+	return api.getStatus(ctx, "innerscan", "inner scan", strconv.Itoa(int(tag)), api.DateMode, from, to)
+}
+
+// getStatus fetches the readings of the comma separated tags from one of
+// the status endpoints (innerscan, sphygmomanometer, pedometer).
+func (api *HealthPlanetAPI) getStatus(ctx context.Context, endpoint, name, tags, dateMode, from, to string) (InnerScanResponse, error) {
 	values := url.Values{}
 	values.Add("access_token", api.AccessToken)
-	values.Add("date", "1")
+	if dateMode == DateModeRegistration {
+		values.Add("date", "0")
+	} else {
+		values.Add("date", "1")
+	}
 	if from != "" {
-		values.Set("date", "1")
 		values.Add("from", from)
 	}
 	if to != "" {
 		values.Add("to", to)
 	}
-	values.Add("tag", strconv.Itoa(int(tag)))
+	values.Add("tag", tags)
 
-	url := fmt.Sprintf("https://www.healthplanet.jp/status/innerscan.json?%s", values.Encode())
+	url := fmt.Sprintf("https://www.healthplanet.jp/status/%s.json?%s", endpoint, values.Encode())
 
 	client := api.Client
 	if client == nil {
 		client = http.DefaultClient
 	}
 
-	res, err := client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return InnerScanResponse{}, errors.Wrap(err, "failed to build request")
+	}
+
+	res, err := client.Do(req)
 	if err != nil {
-		return InnerScanResponse{}, errors.Wrap(err, "failed to fetch response")
+		// The URL in the error holds the access token
+		return InnerScanResponse{}, errors.Wrap(RedactError(err), "failed to fetch response")
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode < 200 || 400 <= res.StatusCode {
-		bodyBytes, _ := io.ReadAll(res.Body)
-		return InnerScanResponse{}, errors.Errorf("failed to get inner scan(invalid status code): %d, body: %s. Note: HealthPlanet API has a rate limit (approx 60 req/hour). If you see 400/401, please wait a while.", res.StatusCode, string(bodyBytes))
+		return InnerScanResponse{}, healthPlanetStatusError(res, name)
 	}
 
 	dec := json.NewDecoder(res.Body)
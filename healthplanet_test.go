@@ -136,3 +136,33 @@ func TestHealthPlanetAPI_AggregateInnerScanData(t *testing.T) {
 		t.Errorf("Fat = %v, want 20.5", *data.Fat)
 	}
 }
+
+func TestHealthPlanetAPI_DateMode(t *testing.T) {
+	var dates []string
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		dates = append(dates, req.URL.Path+" date="+req.URL.Query().Get("date"))
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewBufferString(`{"data": []}`)),
+			Header:     make(http.Header),
+		}
+	})
+	api := &HealthPlanetAPI{AccessToken: "test_token", Client: client, DateMode: DateModeRegistration}
+
+	ctx := context.Background()
+	if _, err := api.GetInnerScan(ctx, InnerScanTagWeight, "20230101000000", "20230102000000"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.GetPedometer(ctx, "20230101000000", "20230102000000"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/status/innerscan.json date=0", "/status/pedometer.json date=1"}
+	if len(dates) != 2 || dates[0] != want[0] || dates[1] != want[1] {
+		t.Errorf("requests = %v, want %v", dates, want)
+	}
+
+	if _, err := ParseDateMode("uploaded"); err == nil {
+		t.Error("ParseDateMode(uploaded) error = nil")
+	}
+}
@@ -0,0 +1,202 @@
+package htf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// InfluxDBSink writes measurements using the InfluxDB 1.x HTTP API
+// (line protocol on /write, InfluxQL on /query). InfluxDB 2.x serves the same
+// endpoints for buckets mapped to a database.
+type InfluxDBSink struct {
+	// URL is the base URL of the instance, e.g. http://localhost:8086
+	URL      string
+	Database string
+	// Measurement is the InfluxDB measurement name. Defaults to "body".
+	Measurement string
+	// Token is sent as "Authorization: Token <token>" when set.
+	Token  string
+	Client *http.Client
+}
+
+type influxQueryResponse struct {
+	Results []struct {
+		Series []struct {
+			Columns []string        `json:"columns"`
+			Values  [][]interface{} `json:"values"`
+		} `json:"series"`
+		Error string `json:"error"`
+	} `json:"results"`
+}
+
+func (s *InfluxDBSink) Name() string {
+	return "influxdb:" + s.Database
+}
+
+func (s *InfluxDBSink) measurement() string {
+	if s.Measurement == "" {
+		return "body"
+	}
+	return s.Measurement
+}
+
+func (s *InfluxDBSink) WriteMeasurement(ctx context.Context, m Measurement) error {
+	return s.write(ctx, []Measurement{m})
+}
+
+// UpdateMeasurements overwrites the fields of ms. InfluxDB replaces the
+// fields of a point written again with the same timestamp.
+func (s *InfluxDBSink) UpdateMeasurements(ctx context.Context, ms []Measurement) error {
+	return s.write(ctx, ms)
+}
+
+// write sends ms in one request of line protocol.
+func (s *InfluxDBSink) write(ctx context.Context, ms []Measurement) error {
+	var lines strings.Builder
+	for _, m := range ms {
+		var fields []string
+		for i, f := range m.fields() {
+			if *f != nil {
+				fields = append(fields, measurementFieldNames[i]+"="+strconv.FormatFloat(**f, 'f', -1, 64))
+			}
+		}
+		if len(fields) > 0 {
+			fmt.Fprintf(&lines, "%s %s %d\n", escapeInfluxName(s.measurement()), strings.Join(fields, ","), m.Time.Unix())
+		}
+	}
+	if lines.Len() == 0 {
+		return nil
+	}
+
+	values := url.Values{}
+	values.Add("db", s.Database)
+	values.Add("precision", "s")
+
+	res, err := s.do(ctx, http.MethodPost, "/write", values, strings.NewReader(lines.String()))
+	if err != nil {
+		return errors.Wrap(err, "failed to write to influxdb")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		bodyBytes, _ := io.ReadAll(res.Body)
+		return errors.Errorf("failed to write to influxdb(invalid status code): %d, body: %s", res.StatusCode, string(bodyBytes))
+	}
+
+	return nil
+}
+
+func (s *InfluxDBSink) ListMeasurements(ctx context.Context, from, to time.Time) ([]Measurement, error) {
+	q := fmt.Sprintf(`SELECT "%s" FROM "%s" WHERE time >= '%s' AND time <= '%s'`,
+		strings.Join(measurementFieldNames, `", "`), s.measurement(), from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+
+	resData, err := s.query(ctx, http.MethodGet, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var ms []Measurement
+	for _, result := range resData.Results {
+		for _, series := range result.Series {
+			for _, row := range series.Values {
+				m, err := influxRowToMeasurement(series.Columns, row)
+				if err != nil {
+					return nil, err
+				}
+				ms = append(ms, m)
+			}
+		}
+	}
+
+	return ms, nil
+}
+
+func (s *InfluxDBSink) DeleteMeasurement(ctx context.Context, m Measurement) error {
+	q := fmt.Sprintf(`DELETE FROM "%s" WHERE time = '%s'`, s.measurement(), m.Time.UTC().Format(time.RFC3339))
+	_, err := s.query(ctx, http.MethodPost, q)
+	return err
+}
+
+func (s *InfluxDBSink) query(ctx context.Context, method, q string) (*influxQueryResponse, error) {
+	values := url.Values{}
+	values.Add("db", s.Database)
+	values.Add("epoch", "s")
+	values.Add("q", q)
+
+	res, err := s.do(ctx, method, "/query", values, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query influxdb")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		bodyBytes, _ := io.ReadAll(res.Body)
+		return nil, errors.Errorf("failed to query influxdb(invalid status code): %d, body: %s", res.StatusCode, string(bodyBytes))
+	}
+
+	var resData influxQueryResponse
+	if err := json.NewDecoder(res.Body).Decode(&resData); err != nil {
+		return nil, errors.Wrap(err, "failed to parse influxdb response")
+	}
+	for _, result := range resData.Results {
+		if result.Error != "" {
+			return nil, errors.Errorf("influxdb query error: %s", result.Error)
+		}
+	}
+
+	return &resData, nil
+}
+
+func (s *InfluxDBSink) do(ctx context.Context, method, path string, values url.Values, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(s.URL, "/")+path+"?"+values.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.Token)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return client.Do(req)
+}
+
+func influxRowToMeasurement(columns []string, row []interface{}) (Measurement, error) {
+	var m Measurement
+	fields := m.fields()
+	for i, col := range columns {
+		if i >= len(row) || row[i] == nil {
+			continue
+		}
+		v, ok := row[i].(float64)
+		if !ok {
+			return Measurement{}, errors.Errorf("unexpected influxdb value for %s: %v", col, row[i])
+		}
+		if col == "time" {
+			m.Time = time.Unix(int64(v), 0).UTC()
+			continue
+		}
+		for j, name := range measurementFieldNames {
+			if col == name {
+				*fields[j] = &v
+			}
+		}
+	}
+	return m, nil
+}
+
+func escapeInfluxName(s string) string {
+	return strings.NewReplacer(",", `\,`, " ", `\ `).Replace(s)
+}
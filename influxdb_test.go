@@ -0,0 +1,102 @@
+package htf
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestInfluxDBSink_WriteMeasurement(t *testing.T) {
+	var gotBody string
+	var gotQuery string
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		b, _ := io.ReadAll(req.Body)
+		gotBody = string(b)
+		gotQuery = req.URL.RawQuery
+		if req.Header.Get("Authorization") != "Token secret" {
+			t.Errorf("Authorization = %q", req.Header.Get("Authorization"))
+		}
+		return &http.Response{
+			StatusCode: 204,
+			Body:       io.NopCloser(bytes.NewBufferString("")),
+			Header:     make(http.Header),
+		}
+	})
+
+	sink := &InfluxDBSink{
+		URL:      "http://localhost:8086/",
+		Database: "health",
+		Token:    "secret",
+		Client:   client,
+	}
+
+	weight := 70.5
+	fat := 20.5
+	ts := time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC)
+	if err := sink.WriteMeasurement(context.Background(), Measurement{Time: ts, Weight: &weight, Fat: &fat}); err != nil {
+		t.Fatalf("WriteMeasurement() error = %v", err)
+	}
+
+	if want := "body weight=70.5,fat=20.5 1672542000\n"; gotBody != want {
+		t.Errorf("body = %q, want %q", gotBody, want)
+	}
+	if want := "db=health&precision=s"; gotQuery != want {
+		t.Errorf("query = %q, want %q", gotQuery, want)
+	}
+}
+
+func TestInfluxDBSink_UpdateMeasurements(t *testing.T) {
+	var gotBody string
+	sink := &InfluxDBSink{URL: "http://localhost:8086/", Database: "health", Client: NewTestClient(func(req *http.Request) *http.Response {
+		b, _ := io.ReadAll(req.Body)
+		gotBody = string(b)
+		return &http.Response{StatusCode: 204, Body: io.NopCloser(bytes.NewBufferString("")), Header: make(http.Header)}
+	})}
+
+	weight, trend1, trend2 := 70.5, 70.2, 70.3
+	ts := time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC)
+	err := sink.UpdateMeasurements(context.Background(), []Measurement{
+		{Time: ts, Weight: &weight, WeightTrend: &trend1},
+		{Time: ts.AddDate(0, 0, 1), WeightTrend: &trend2},
+	})
+	if err != nil {
+		t.Fatalf("UpdateMeasurements() error = %v", err)
+	}
+	// Points with the same timestamp are overwritten in one request
+	if want := "body weight=70.5,weight_trend=70.2 1672542000\nbody weight_trend=70.3 1672628400\n"; gotBody != want {
+		t.Errorf("body = %q, want %q", gotBody, want)
+	}
+}
+
+func TestInfluxDBSink_ListMeasurements(t *testing.T) {
+	resp := `{"results":[{"series":[{"name":"body","columns":["time","weight","fat"],"values":[[1672542000,70.5,20.5],[1672628400,70.1,null]]}]}]}`
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		if req.URL.Path != "/query" {
+			t.Errorf("path = %q", req.URL.Path)
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewBufferString(resp)),
+			Header:     make(http.Header),
+		}
+	})
+
+	sink := &InfluxDBSink{URL: "http://localhost:8086", Database: "health", Client: client}
+
+	ms, err := sink.ListMeasurements(context.Background(), time.Unix(0, 0), time.Now())
+	if err != nil {
+		t.Fatalf("ListMeasurements() error = %v", err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("ListMeasurements() got %d items, want 2", len(ms))
+	}
+	if !ms[0].Time.Equal(time.Unix(1672542000, 0)) || *ms[0].Weight != 70.5 || *ms[0].Fat != 20.5 {
+		t.Errorf("ms[0] = %+v", ms[0])
+	}
+	if ms[1].Fat != nil {
+		t.Errorf("ms[1].Fat = %v, want nil", *ms[1].Fat)
+	}
+}
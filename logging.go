@@ -0,0 +1,59 @@
+package htf
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// sensitiveLogKeys are attribute key fragments whose values are never logged.
+var sensitiveLogKeys = []string{"token", "secret", "password", "code_verifier"}
+
+// NewLogger returns a structured logger writing to w. format is either
+// LogFormatText or LogFormatJSON, level one of debug, info, warn or error.
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, errors.Errorf("unknown log level: %s", level)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       lvl,
+		ReplaceAttr: maskSensitiveAttr,
+	}
+
+	switch format {
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, errors.Errorf("unknown log format: %s", format)
+	}
+}
+
+// maskSensitiveAttr drops the values of sensitive keys and redacts the
+// secrets in strings and errors (see Redact), such as URLs in errors.
+func maskSensitiveAttr(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, k := range sensitiveLogKeys {
+		if strings.Contains(key, k) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+
+	switch v := a.Value.Any().(type) {
+	case string:
+		return slog.String(a.Key, Redact(v))
+	case error:
+		return slog.String(a.Key, Redact(v.Error()))
+	}
+	return a
+}
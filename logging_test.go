@@ -0,0 +1,42 @@
+package htf
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, LogFormatJSON, "info")
+	if err != nil {
+		t.Fatalf("NewLogger() error = %v", err)
+	}
+
+	logger.Debug("hidden")
+	logger.Info("saved", "metric", "weight", "value", 70.5, "access_token", "secret-access", "client_secret", "secret-client")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1: %s", len(lines), buf.String())
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("invalid json log: %v", err)
+	}
+	if entry["msg"] != "saved" || entry["metric"] != "weight" || entry["value"] != 70.5 {
+		t.Errorf("entry = %v", entry)
+	}
+	if strings.Contains(buf.String(), "secret-") {
+		t.Errorf("secrets were logged: %s", buf.String())
+	}
+
+	if _, err := NewLogger(&buf, "xml", "info"); err == nil {
+		t.Error("NewLogger(xml) error = nil, want error")
+	}
+	if _, err := NewLogger(&buf, LogFormatText, "verbose"); err == nil {
+		t.Error("NewLogger(verbose) error = nil, want error")
+	}
+}
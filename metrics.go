@@ -0,0 +1,226 @@
+package htf
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const metricsPrefix = "healthplanet_to_fitbit_"
+
+// Metrics collects sync health metrics and renders them in the Prometheus
+// text exposition format.
+type Metrics struct {
+	mu sync.Mutex
+
+	lastSuccess        time.Time
+	readingsFetched    int
+	readingsCreated    int
+	skippedCache       int
+	skippedExisting    int
+	apiCalls           map[string]int
+	apiErrors          map[string]int
+	rateLimitRemaining map[string]float64
+	tokenExpiry        map[string]time.Time
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		apiCalls:           make(map[string]int),
+		apiErrors:          make(map[string]int),
+		rateLimitRemaining: make(map[string]float64),
+		tokenExpiry:        make(map[string]time.Time),
+	}
+}
+
+func (m *Metrics) SetLastSuccess(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSuccess = t
+}
+
+func (m *Metrics) AddFetched(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readingsFetched += n
+}
+
+func (m *Metrics) IncCreated() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readingsCreated++
+}
+
+func (m *Metrics) IncSkippedCache() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.skippedCache++
+}
+
+func (m *Metrics) IncSkippedExisting() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.skippedExisting++
+}
+
+func (m *Metrics) SetTokenExpiry(provider string, t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokenExpiry[provider] = t
+}
+
+func (m *Metrics) observeResponse(provider string, res *http.Response, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.apiCalls[provider]++
+	if err != nil || res.StatusCode >= 400 {
+		m.apiErrors[provider]++
+	}
+	if res == nil {
+		return
+	}
+	if v := res.Header.Get("Fitbit-Rate-Limit-Remaining"); v != "" {
+		if remaining, err := strconv.ParseFloat(v, 64); err == nil {
+			m.rateLimitRemaining[provider] = remaining
+		}
+	}
+}
+
+// Transport wraps base so that every request is counted for provider.
+func (m *Metrics) Transport(provider string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &metricsTransport{metrics: m, provider: provider, base: base}
+}
+
+type metricsTransport struct {
+	metrics  *Metrics
+	provider string
+	base     http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	t.metrics.observeResponse(t.provider, res, err)
+	return res, err
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+
+	writeMetric(cw, "last_success_timestamp_seconds", "gauge", "Time of the last successful sync.", nil, unixSeconds(m.lastSuccess))
+	writeMetric(cw, "readings_fetched_total", "counter", "Readings fetched from HealthPlanet.", nil, float64(m.readingsFetched))
+	writeMetric(cw, "readings_created_total", "counter", "Readings created in Fitbit.", nil, float64(m.readingsCreated))
+	writeMetric(cw, "readings_skipped_total", "counter", "Readings skipped because they were already synced.", map[string]float64{
+		`reason="cache"`:    float64(m.skippedCache),
+		`reason="existing"`: float64(m.skippedExisting),
+	}, 0)
+	writeMetric(cw, "api_calls_total", "counter", "API calls per provider.", providerValues(m.apiCalls), 0)
+	writeMetric(cw, "api_errors_total", "counter", "Failed API calls per provider.", providerValues(m.apiErrors), 0)
+
+	remaining := make(map[string]float64, len(m.rateLimitRemaining))
+	for p, v := range m.rateLimitRemaining {
+		remaining[providerLabel(p)] = v
+	}
+	writeMetric(cw, "rate_limit_remaining", "gauge", "Remaining API calls in the current rate limit window.", remaining, 0)
+
+	expiry := make(map[string]float64, len(m.tokenExpiry))
+	for p, t := range m.tokenExpiry {
+		expiry[providerLabel(p)] = unixSeconds(t)
+	}
+	writeMetric(cw, "token_expiry_timestamp_seconds", "gauge", "Expiry time of the access token.", expiry, 0)
+
+	return cw.n, cw.err
+}
+
+// WriteTextfile atomically writes the metrics to path for the node_exporter
+// textfile collector.
+func (m *Metrics) WriteTextfile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := m.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeMetric writes a metric family. If labeled is nil, a single unlabeled
+// sample with value is written.
+func writeMetric(w io.Writer, name, typ, help string, labeled map[string]float64, value float64) {
+	name = metricsPrefix + name
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+
+	if labeled == nil {
+		fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+		return
+	}
+
+	labels := make([]string, 0, len(labeled))
+	for l := range labeled {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, l, strconv.FormatFloat(labeled[l], 'g', -1, 64))
+	}
+}
+
+func providerLabel(provider string) string {
+	return fmt.Sprintf("provider=%q", provider)
+}
+
+func providerValues(m map[string]int) map[string]float64 {
+	values := make(map[string]float64, len(m))
+	for p, v := range m {
+		values[providerLabel(p)] = float64(v)
+	}
+	return values
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.Unix())
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,78 @@
+package htf
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+
+	status := 200
+	base := RoundTripFunc(func(req *http.Request) *http.Response {
+		header := make(http.Header)
+		header.Set("Fitbit-Rate-Limit-Remaining", "147")
+		return &http.Response{
+			StatusCode: status,
+			Body:       io.NopCloser(bytes.NewBufferString("")),
+			Header:     header,
+		}
+	})
+	client := &http.Client{Transport: m.Transport("fitbit", base)}
+
+	for _, s := range []int{200, 429} {
+		status = s
+		res, err := client.Get("https://api.fitbit.com/")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		res.Body.Close()
+	}
+
+	m.AddFetched(3)
+	m.IncCreated()
+	m.IncSkippedCache()
+	m.IncSkippedExisting()
+	m.SetLastSuccess(time.Unix(1672542000, 0))
+	m.SetTokenExpiry("fitbit", time.Unix(1672545600, 0))
+
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+
+	for _, want := range []string{
+		"# TYPE healthplanet_to_fitbit_last_success_timestamp_seconds gauge\n",
+		"healthplanet_to_fitbit_last_success_timestamp_seconds 1.672542e+09\n",
+		"healthplanet_to_fitbit_readings_fetched_total 3\n",
+		"healthplanet_to_fitbit_readings_created_total 1\n",
+		`healthplanet_to_fitbit_readings_skipped_total{reason="cache"} 1` + "\n",
+		`healthplanet_to_fitbit_readings_skipped_total{reason="existing"} 1` + "\n",
+		`healthplanet_to_fitbit_api_calls_total{provider="fitbit"} 2` + "\n",
+		`healthplanet_to_fitbit_api_errors_total{provider="fitbit"} 1` + "\n",
+		`healthplanet_to_fitbit_rate_limit_remaining{provider="fitbit"} 147` + "\n",
+		`healthplanet_to_fitbit_token_expiry_timestamp_seconds{provider="fitbit"} 1.6725456e+09` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics output does not contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "htf.prom")
+	if err := m.WriteTextfile(path); err != nil {
+		t.Fatalf("WriteTextfile() error = %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read textfile: %v", err)
+	}
+	if string(b) != buf.String() {
+		t.Errorf("textfile content differs from WriteTo output")
+	}
+}
@@ -0,0 +1,256 @@
+package htf
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	NotifyEventFailure       = "failure"
+	NotifyEventSuccess       = "success"
+	NotifyEventTokenExpiring = "token_expiring"
+)
+
+// SyncSummary describes the outcome of a sync run sent to notifiers.
+type SyncSummary struct {
+	Event       string     `json:"event"`
+	Time        time.Time  `json:"time"`
+	Fetched     int        `json:"fetched"`
+	Created     int        `json:"created"`
+	Provider    string     `json:"provider,omitempty"`
+	StatusCode  int        `json:"status_code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	TokenExpiry *time.Time `json:"token_expiry,omitempty"`
+}
+
+// Title returns a one-line description of the summary.
+func (s SyncSummary) Title() string {
+	switch s.Event {
+	case NotifyEventFailure:
+		if s.StatusCode != 0 {
+			return fmt.Sprintf("healthplanet-to-fitbit: sync failed (%s, status %d)", s.Provider, s.StatusCode)
+		}
+		if s.Provider != "" {
+			return fmt.Sprintf("healthplanet-to-fitbit: sync failed (%s)", s.Provider)
+		}
+		return "healthplanet-to-fitbit: sync failed"
+	case NotifyEventSuccess:
+		return fmt.Sprintf("healthplanet-to-fitbit: synced %d new readings", s.Created)
+	case NotifyEventTokenExpiring:
+		return fmt.Sprintf("healthplanet-to-fitbit: %s token expires soon", s.Provider)
+	default:
+		return "healthplanet-to-fitbit: " + s.Event
+	}
+}
+
+// Text returns a human-readable description of the summary.
+func (s SyncSummary) Text() string {
+	lines := []string{s.Title()}
+	switch s.Event {
+	case NotifyEventFailure:
+		if s.Error != "" {
+			lines = append(lines, s.Error)
+		}
+		lines = append(lines, fmt.Sprintf("fetched: %d, created before failure: %d", s.Fetched, s.Created))
+	case NotifyEventSuccess:
+		lines = append(lines, fmt.Sprintf("fetched: %d, created: %d", s.Fetched, s.Created))
+	case NotifyEventTokenExpiring:
+		if s.TokenExpiry != nil {
+			lines = append(lines, fmt.Sprintf("expires at %s, please re-authorize", s.TokenExpiry.Format(time.RFC3339)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, s SyncSummary) error
+}
+
+// Notifiers sends the summary to every notifier, even if some of them fail.
+type Notifiers []Notifier
+
+func (ns Notifiers) Notify(ctx context.Context, s SyncSummary) error {
+	var errs []error
+	for _, n := range ns {
+		if err := n.Notify(ctx, s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return stderrors.Join(errs...)
+}
+
+// WebhookNotifier posts the summary as JSON to a generic webhook.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, s SyncSummary) error {
+	return postJSON(ctx, n.Client, n.URL, s)
+}
+
+// SlackNotifier posts the summary to a Slack-compatible incoming webhook.
+type SlackNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, s SyncSummary) error {
+	return postJSON(ctx, n.Client, n.URL, map[string]string{"text": s.Text()})
+}
+
+// DiscordNotifier posts the summary to a Discord webhook.
+type DiscordNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *DiscordNotifier) Notify(ctx context.Context, s SyncSummary) error {
+	return postJSON(ctx, n.Client, n.URL, map[string]string{"content": s.Text()})
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode notification")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to build notification request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		// Webhook URLs hold their secret
+		return errors.Wrap(RedactError(err), "failed to send notification")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		bodyBytes, _ := io.ReadAll(res.Body)
+		return errors.Errorf("failed to send notification(invalid status code): %d, body: %s", res.StatusCode, string(bodyBytes))
+	}
+
+	return nil
+}
+
+// SMTPNotifier sends the summary as a plain text email.
+type SMTPNotifier struct {
+	// Addr is the host:port of the SMTP server.
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, s SyncSummary) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		host, _, err := net.SplitHostPort(n.Addr)
+		if err != nil {
+			return errors.Wrap(err, "invalid smtp address")
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", s.Title())
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(&msg, "\r\n%s\r\n", strings.ReplaceAll(s.Text(), "\n", "\r\n"))
+
+	if err := n.send(ctx, auth, msg.Bytes()); err != nil {
+		return errors.Wrap(err, "failed to send email")
+	}
+
+	return nil
+}
+
+// send is smtp.SendMail, bounded by ctx: the connection is dialed with ctx
+// and closed when ctx is done, so a server that stops responding cannot
+// hold up the run.
+func (n *SMTPNotifier) send(ctx context.Context, auth smtp.Auth, msg []byte) (err error) {
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		return errors.Wrap(err, "invalid smtp address")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", n.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer func() {
+		if !stop() && err != nil {
+			// The connection was closed under the client
+			err = context.Cause(ctx)
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return stderrors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	for _, to := range n.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
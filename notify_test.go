@@ -0,0 +1,198 @@
+package htf
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifiers(t *testing.T) {
+	var bodies []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid json: %v", err)
+		}
+		bodies = append(bodies, body)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	notifier := Notifiers{
+		&WebhookNotifier{URL: srv.URL + "/webhook"},
+		&WebhookNotifier{URL: srv.URL + "/fail"},
+		&SlackNotifier{URL: srv.URL + "/slack"},
+		&DiscordNotifier{URL: srv.URL + "/discord"},
+	}
+
+	summary := SyncSummary{
+		Event:      NotifyEventFailure,
+		Time:       time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Fetched:    10,
+		Created:    2,
+		Provider:   "fitbit",
+		StatusCode: 429,
+		Error:      "Fitbit API limit exceeded",
+	}
+
+	err := notifier.Notify(context.Background(), summary)
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("Notify() error = %v, want status 500 error", err)
+	}
+
+	// The failing webhook must not stop the others
+	if len(bodies) != 4 {
+		t.Fatalf("got %d requests, want 4", len(bodies))
+	}
+	if bodies[0]["event"] != "failure" || bodies[0]["status_code"] != float64(429) || bodies[0]["created"] != float64(2) {
+		t.Errorf("webhook body = %v", bodies[0])
+	}
+	if text, _ := bodies[2]["text"].(string); !strings.HasPrefix(text, "healthplanet-to-fitbit: sync failed (fitbit, status 429)\n") {
+		t.Errorf("slack body = %v", bodies[2])
+	}
+	if content, _ := bodies[3]["content"].(string); !strings.Contains(content, "Fitbit API limit exceeded") {
+		t.Errorf("discord body = %v", bodies[3])
+	}
+}
+
+// fakeSMTPServer accepts a single mail and sends its DATA to the returned channel.
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	data := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = io.WriteString(conn, s+"\r\n") }
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var b strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					b.WriteString(l)
+				}
+				data <- b.String()
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("500 unknown command")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), data
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, data := fakeSMTPServer(t)
+
+	n := &SMTPNotifier{
+		Addr: addr,
+		From: "htf@example.com",
+		To:   []string{"me@example.com"},
+	}
+
+	expiry := time.Date(2023, 1, 8, 0, 0, 0, 0, time.UTC)
+	summary := SyncSummary{Event: NotifyEventTokenExpiring, Provider: "healthplanet", TokenExpiry: &expiry}
+	if err := n.Notify(context.Background(), summary); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	select {
+	case msg := <-data:
+		for _, want := range []string{
+			"To: me@example.com\r\n",
+			"Subject: healthplanet-to-fitbit: healthplanet token expires soon\r\n",
+			"expires at 2023-01-08T00:00:00Z, please re-authorize",
+		} {
+			if !strings.Contains(msg, want) {
+				t.Errorf("mail does not contain %q:\n%s", want, msg)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("mail was not received")
+	}
+}
+
+func TestSMTPNotifier_Context(t *testing.T) {
+	// A server that accepts connections and never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	n := &SMTPNotifier{Addr: ln.Addr().String(), From: "htf@example.com", To: []string{"me@example.com"}}
+	summary := SyncSummary{Event: NotifyEventFailure, Provider: "fitbit", Error: "failed"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = n.Notify(ctx, summary)
+	if err == nil || time.Since(start) > 2*time.Second {
+		t.Errorf("Notify() = %v after %v, want a timeout", err, time.Since(start))
+	}
+
+	// Cancellation without a deadline also stops it
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start = time.Now()
+	err = n.Notify(ctx, summary)
+	if !errors.Is(err, context.Canceled) || time.Since(start) > 2*time.Second {
+		t.Errorf("Notify() = %v after %v, want context.Canceled", err, time.Since(start))
+	}
+}
@@ -0,0 +1,176 @@
+package htf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PedometerTagSteps is the tag of the step count in the pedometer endpoint.
+const PedometerTagSteps = "6331"
+
+// FitbitActivityWalk is the Fitbit activity steps are logged as by default.
+const FitbitActivityWalk int64 = 90013
+
+// stepDuration is the assumed time per step (100 steps per minute) used for
+// the duration Fitbit requires for an activity log.
+const stepDuration = 600 * time.Millisecond
+
+// StepsDataMap is the step count per day, keyed by the start of the day in JST.
+type StepsDataMap map[time.Time]int
+
+// GetPedometer returns the step counts between from and to (YYYYMMDDHHMMSS).
+// An empty from returns the last 3 months.
+func (api *HealthPlanetAPI) GetPedometer(ctx context.Context, from, to string) (InnerScanResponse, error) {
+	return api.getStatus(ctx, "pedometer", "pedometer", PedometerTagSteps, DateModeMeasurement, from, to)
+}
+
+// AggregateDailySteps fetches the step counts in 3-month chunks and sums
+// them per day.
+func (api *HealthPlanetAPI) AggregateDailySteps(ctx context.Context, from, to string) (StepsDataMap, error) {
+	chunks, err := healthPlanetChunks(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// Readings may be repeated across uploads; count each time once
+	readings := map[time.Time]int{}
+	for _, c := range chunks {
+		res, err := api.GetPedometer(ctx, c.from, c.to)
+		if err != nil {
+			return nil, err
+		}
+		for _, data := range res.Data {
+			if data.Tag != PedometerTagSteps {
+				continue
+			}
+			t, err := data.Time()
+			if err != nil {
+				slog.Warn("invalid time", "date", data.Date, "metric", "steps", "error", err)
+				continue
+			}
+			steps, err := strconv.Atoi(data.KeyData)
+			if err != nil {
+				slog.Warn("invalid steps", "date", data.Date, "metric", "steps", "value", data.KeyData, "error", err)
+				continue
+			}
+			readings[t] = steps
+		}
+	}
+
+	m := StepsDataMap{}
+	for t, steps := range readings {
+		m[startOfDay(t)] += steps
+	}
+
+	return m, nil
+}
+
+// Days returns the days of the map in chronological order.
+func (m StepsDataMap) Days() []time.Time {
+	days := make([]time.Time, 0, len(m))
+	for d := range m {
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+// StepsExportRecords returns one record per day, ordered by time.
+func StepsExportRecords(data StepsDataMap) []ExportRecord {
+	var records []ExportRecord
+	for _, d := range data.Days() {
+		records = append(records, ExportRecord{
+			Tag:       PedometerTagSteps,
+			Value:     float64(data[d]),
+			Unit:      "steps",
+			LocalTime: d.In(tz).Format(time.RFC3339),
+			UTCTime:   d.UTC().Format(time.RFC3339),
+		})
+	}
+	return records
+}
+
+func startOfDay(t time.Time) time.Time {
+	d := t.In(tz)
+	return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, tz)
+}
+
+// GetActivityLogResponse is the part of the daily activity summary used to
+// find existing activity logs.
+type GetActivityLogResponse struct {
+	Activities []struct {
+		ActivityId int64  `json:"activityId"`
+		LogId      int64  `json:"logId"`
+		Name       string `json:"name"`
+		Steps      int    `json:"steps"`
+		StartDate  string `json:"startDate"`
+		StartTime  string `json:"startTime"`
+	} `json:"activities"`
+}
+
+// GetActivityLog returns the activities logged on day (a JST date).
+func (api *FitbitAPI) GetActivityLog(ctx context.Context, day time.Time) (*GetActivityLogResponse, error) {
+	res, err := api.do(ctx, "GET", fmt.Sprintf("https://api.fitbit.com/1/user/-/activities/date/%s.json", day.In(tz).Format("2006-01-02")))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get activity log in fitbit")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		return nil, fitbitStatusError(res, "get activity log")
+	}
+
+	var resData GetActivityLogResponse
+	if err := json.NewDecoder(res.Body).Decode(&resData); err != nil {
+		return nil, errors.Wrap(err, "failed to parse activity log in fitbit")
+	}
+
+	return &resData, nil
+}
+
+// HasStepsLog reports whether an activity with activityId is already logged
+// on day.
+func (api *FitbitAPI) HasStepsLog(ctx context.Context, activityId int64, day time.Time) (bool, error) {
+	res, err := api.GetActivityLog(ctx, day)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range res.Activities {
+		if a.ActivityId == activityId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CreateStepsLog logs the steps of day (a JST date) as an activity starting
+// at midnight, with a duration estimated from the step count.
+func (api *FitbitAPI) CreateStepsLog(ctx context.Context, activityId int64, day time.Time, steps int) error {
+	values := url.Values{}
+	values.Add("activityId", strconv.FormatInt(activityId, 10))
+	values.Add("date", day.In(tz).Format("2006-01-02"))
+	values.Add("startTime", "00:00")
+	values.Add("durationMillis", strconv.FormatInt((time.Duration(steps)*stepDuration).Milliseconds(), 10))
+	values.Add("distance", strconv.Itoa(steps))
+	values.Add("distanceUnit", "steps")
+
+	res, err := api.do(ctx, "POST", fmt.Sprintf("https://api.fitbit.com/1/user/-/activities.json?%s", values.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "failed to create activity log in fitbit")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		return fitbitStatusError(res, "create activity log")
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package htf
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHealthPlanetAPI_AggregateDailySteps(t *testing.T) {
+	resp := `{
+		"data": [
+			{"date": "202301010000", "keydata": "8000", "model": "pm", "tag": "6331"},
+			{"date": "202301011200", "keydata": "500", "model": "pm", "tag": "6331"},
+			{"date": "202301020000", "keydata": "6000", "model": "pm", "tag": "6331"}
+		]
+	}`
+
+	var paths []string
+	api := &HealthPlanetAPI{AccessToken: "test_token", Client: NewTestClient(func(req *http.Request) *http.Response {
+		paths = append(paths, req.URL.Path)
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewBufferString(resp)),
+			Header:     make(http.Header),
+		}
+	})}
+
+	got, err := api.AggregateDailySteps(context.Background(), "", "")
+	if err != nil {
+		t.Fatalf("AggregateDailySteps() error = %v", err)
+	}
+	if paths[0] != "/status/pedometer.json" {
+		t.Errorf("path = %s", paths[0])
+	}
+
+	d1 := time.Date(2023, 1, 1, 0, 0, 0, 0, tz)
+	d2 := d1.AddDate(0, 0, 1)
+	if len(got) != 2 || got[d1] != 8500 || got[d2] != 6000 {
+		t.Errorf("AggregateDailySteps() = %v", got)
+	}
+
+	records := StepsExportRecords(got)
+	if len(records) != 2 || records[0].Value != 8500 || records[0].LocalTime != "2023-01-01T00:00:00+09:00" {
+		t.Errorf("StepsExportRecords() = %+v", records)
+	}
+}
+
+func TestFitbitAPI_StepsLog(t *testing.T) {
+	var requests []*http.Request
+	api := &FitbitAPI{Client: NewTestClient(func(req *http.Request) *http.Response {
+		requests = append(requests, req)
+		body := `{}`
+		if req.Method == "GET" {
+			body = `{"activities":[{"activityId":90013,"logId":1,"steps":8500}]}`
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})}
+	day := time.Date(2023, 1, 1, 0, 0, 0, 0, tz)
+
+	found, err := api.HasStepsLog(context.Background(), FitbitActivityWalk, day)
+	if err != nil {
+		t.Fatalf("HasStepsLog() error = %v", err)
+	}
+	if !found {
+		t.Error("HasStepsLog() = false, want true")
+	}
+	if requests[0].URL.Path != "/1/user/-/activities/date/2023-01-01.json" {
+		t.Errorf("path = %s", requests[0].URL.Path)
+	}
+
+	found, err = api.HasStepsLog(context.Background(), 1234, day)
+	if err != nil || found {
+		t.Errorf("HasStepsLog(other activity) = %v, %v", found, err)
+	}
+
+	if err := api.CreateStepsLog(context.Background(), FitbitActivityWalk, day, 8500); err != nil {
+		t.Fatalf("CreateStepsLog() error = %v", err)
+	}
+	q := requests[2].URL.Query()
+	if q.Get("date") != "2023-01-01" || q.Get("distance") != "8500" || q.Get("distanceUnit") != "steps" || q.Get("durationMillis") != "5100000" {
+		t.Errorf("query = %v", q)
+	}
+}
@@ -0,0 +1,118 @@
+package htf
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// redacted replaces the secrets removed by Redact.
+const redacted = "[REDACTED]"
+
+// sensitiveParams are the query, form and JSON fields holding secrets.
+const sensitiveParams = `access_token|refresh_token|id_token|client_secret|code|code_verifier|password`
+
+// secretPatterns match the secrets in free text, with the part to keep in
+// the first group.
+var secretPatterns = []*regexp.Regexp{
+	// access_token=... in URLs, forms and logfmt
+	regexp.MustCompile(`((?:^|[?&\s"'])(?:` + sensitiveParams + `)=)[^&\s"']+`),
+	// "access_token": "..." in JSON
+	regexp.MustCompile(`("(?:` + sensitiveParams + `)"\s*:\s*")[^"]*`),
+	// Authorization headers
+	regexp.MustCompile(`(?i)(\b(?:Bearer|Basic)\s+)[A-Za-z0-9._~+/=-]+`),
+	// JWTs, such as Fitbit access tokens
+	regexp.MustCompile(`()eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	// Fitbit quotes tokens in its error messages, e.g. "Refresh token invalid: ..."
+	regexp.MustCompile(`(?i)((?:access|refresh) token[^:"]{0,20}:\s*)[^\s,."]+`),
+	// Webhook URLs carry their secret in the path
+	regexp.MustCompile(`((?:hooks\.slack\.com/services|discord(?:app)?\.com/api/webhooks)/)[^\s"']+`),
+}
+
+// Redact masks the tokens, client secrets, authorization codes and webhook
+// secrets in s.
+func Redact(s string) string {
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, "${1}"+redacted)
+	}
+	return s
+}
+
+// RedactURL returns u as a string with the values of its secret query
+// parameters masked.
+func RedactURL(u *url.URL) string {
+	return Redact(u.String())
+}
+
+// MaskSecret masks a token or secret for display, keeping the first four
+// characters of long ones so that they can be told apart.
+func MaskSecret(s string) string {
+	if len(s) < 16 {
+		return redacted
+	}
+	return s[:4] + "..." + redacted
+}
+
+// RedactError returns err with the secrets in its message masked (see
+// Redact). errors.Is and errors.As still see the original chain. It returns
+// nil for a nil err.
+func RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err: err}
+}
+
+type redactedError struct {
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return Redact(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Format redacts the error formatted with any verb, such as the %+v of
+// github.com/pkg/errors.
+func (e *redactedError) Format(s fmt.State, verb rune) {
+	_, _ = io.WriteString(s, Redact(fmt.Sprintf(fmt.FormatString(s, verb), e.err)))
+}
+
+// DebugTransport logs every request at debug level, with secrets redacted.
+// Headers and bodies are not logged.
+type DebugTransport struct {
+	Base http.RoundTripper
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+func (t *DebugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	logger := t.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if !logger.Enabled(req.Context(), slog.LevelDebug) {
+		return base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	res, err := base.RoundTrip(req)
+	attrs := []any{"method", req.Method, "url", RedactURL(req.URL), "duration", time.Since(start)}
+	if err != nil {
+		logger.Debug("http request failed", append(attrs, "error", RedactError(err))...)
+		return nil, err
+	}
+	logger.Debug("http request", append(attrs, "status_code", res.StatusCode)...)
+	return res, nil
+}
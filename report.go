@@ -0,0 +1,340 @@
+package htf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ReportPeriodWeek  = "week"
+	ReportPeriodMonth = "month"
+
+	ReportFormatMarkdown = "markdown"
+	ReportFormatHTML     = "html"
+)
+
+// ReportPeriodRange returns the calendar week (Monday to Sunday) or month
+// containing date, in JST. to is exclusive.
+func ReportPeriodRange(period string, date time.Time) (from, to time.Time, err error) {
+	d := date.In(tz)
+	day := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, tz)
+
+	switch period {
+	case ReportPeriodWeek:
+		offset := (int(day.Weekday()) + 6) % 7 // days since Monday
+		from = day.AddDate(0, 0, -offset)
+		return from, from.AddDate(0, 0, 7), nil
+	case ReportPeriodMonth:
+		from = time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, tz)
+		return from, from.AddDate(0, 1, 0), nil
+	default:
+		return time.Time{}, time.Time{}, errors.Errorf("unknown report period: %s", period)
+	}
+}
+
+type ReportPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// MetricStats summarizes one metric over a report period.
+type MetricStats struct {
+	Name   string
+	Unit   string
+	Points []ReportPoint
+	Count  int
+	Min    float64
+	Max    float64
+	Mean   float64
+	// SlopePerWeek is the least squares trend in units per week.
+	SlopePerWeek float64
+	// Change is the difference between this and the previous period's mean,
+	// or nil if the previous period has no data.
+	Change *float64
+}
+
+type Report struct {
+	Period  string
+	From    time.Time
+	To      time.Time
+	Metrics []MetricStats
+}
+
+type reportSeries struct {
+	name  string
+	unit  string
+	value func(Measurement) (float64, bool)
+}
+
+var reportSeriesList = []reportSeries{
+	{"Weight", WeightUnitKg, func(m Measurement) (float64, bool) {
+		if m.Weight == nil {
+			return 0, false
+		}
+		return *m.Weight, true
+	}},
+	{"Body fat", "%", func(m Measurement) (float64, bool) {
+		if m.Fat == nil {
+			return 0, false
+		}
+		return *m.Fat, true
+	}},
+	{"Fat mass", WeightUnitKg, func(m Measurement) (float64, bool) {
+		if m.Weight == nil || m.Fat == nil {
+			return 0, false
+		}
+		return *m.Weight * *m.Fat / 100, true
+	}},
+	{"Lean mass", WeightUnitKg, func(m Measurement) (float64, bool) {
+		if m.Weight == nil || m.Fat == nil {
+			return 0, false
+		}
+		return *m.Weight - *m.Weight**m.Fat/100, true
+	}},
+}
+
+// BuildReport computes the statistics of the measurements in [from, to),
+// comparing them with the measurements of the previous period.
+func BuildReport(period string, from, to time.Time, current, previous []Measurement) Report {
+	r := Report{Period: period, From: from, To: to}
+
+	for _, s := range reportSeriesList {
+		stats := MetricStats{Name: s.name, Unit: s.unit}
+		stats.Points = seriesPoints(current, s)
+		stats.Count = len(stats.Points)
+
+		if stats.Count > 0 {
+			stats.Min, stats.Max = math.Inf(1), math.Inf(-1)
+			sum := 0.0
+			for _, p := range stats.Points {
+				stats.Min = math.Min(stats.Min, p.Value)
+				stats.Max = math.Max(stats.Max, p.Value)
+				sum += p.Value
+			}
+			stats.Mean = sum / float64(stats.Count)
+			stats.SlopePerWeek = slopePerDay(stats.Points) * 7
+
+			if prev := seriesPoints(previous, s); len(prev) > 0 {
+				prevSum := 0.0
+				for _, p := range prev {
+					prevSum += p.Value
+				}
+				change := stats.Mean - prevSum/float64(len(prev))
+				stats.Change = &change
+			}
+		}
+
+		r.Metrics = append(r.Metrics, stats)
+	}
+
+	return r
+}
+
+// InUnit returns the report with weights converted to unit.
+func (r Report) InUnit(unit string) Report {
+	metrics := make([]MetricStats, len(r.Metrics))
+	for i, m := range r.Metrics {
+		if m.Unit == WeightUnitKg && unit != WeightUnitKg {
+			// Conversion is linear, so every statistic scales the same way
+			conv := func(v float64) float64 { return ConvertWeight(v, unit) }
+			points := make([]ReportPoint, len(m.Points))
+			for j, p := range m.Points {
+				points[j] = ReportPoint{Time: p.Time, Value: conv(p.Value)}
+			}
+			m.Points = points
+			m.Unit = unit
+			m.Min, m.Max, m.Mean = conv(m.Min), conv(m.Max), conv(m.Mean)
+			m.SlopePerWeek = conv(m.SlopePerWeek)
+			if m.Change != nil {
+				change := conv(*m.Change)
+				m.Change = &change
+			}
+		}
+		metrics[i] = m
+	}
+	r.Metrics = metrics
+	return r
+}
+
+func seriesPoints(ms []Measurement, s reportSeries) []ReportPoint {
+	var points []ReportPoint
+	for _, m := range ms {
+		if v, ok := s.value(m); ok {
+			points = append(points, ReportPoint{Time: m.Time, Value: v})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points
+}
+
+// slopePerDay returns the least squares slope of the points in units per day.
+func slopePerDay(points []ReportPoint) float64 {
+	if len(points) < 2 {
+		return 0
+	}
+
+	t0 := points[0].Time
+	var sumX, sumY, sumXY, sumXX float64
+	for _, p := range points {
+		x := p.Time.Sub(t0).Hours() / 24
+		sumX += x
+		sumY += p.Value
+		sumXY += x * p.Value
+		sumXX += x * x
+	}
+
+	n := float64(len(points))
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denom
+}
+
+// SVGChart renders the points as a simple inline SVG line chart.
+func SVGChart(title, unit string, points []ReportPoint, from, to time.Time) string {
+	const (
+		width  = 600.0
+		height = 200.0
+		padX   = 50.0
+		padY   = 20.0
+	)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="sans-serif" font-size="11">`, width, height, width, height)
+	fmt.Fprintf(&b, `<rect width="%.0f" height="%.0f" fill="#fff"/>`, width, height)
+	fmt.Fprintf(&b, `<text x="%.0f" y="14" font-weight="bold">%s (%s)</text>`, padX, htmltemplate.HTMLEscapeString(title), htmltemplate.HTMLEscapeString(unit))
+
+	if len(points) == 0 {
+		fmt.Fprintf(&b, `<text x="%.0f" y="%.0f">no data</text></svg>`, width/2-20, height/2)
+		return b.String()
+	}
+
+	lo, hi := points[0].Value, points[0].Value
+	for _, p := range points {
+		lo = math.Min(lo, p.Value)
+		hi = math.Max(hi, p.Value)
+	}
+	if hi == lo {
+		lo, hi = lo-1, hi+1
+	}
+
+	span := to.Sub(from).Seconds()
+	x := func(t time.Time) float64 {
+		return padX + (width-2*padX)*t.Sub(from).Seconds()/span
+	}
+	y := func(v float64) float64 {
+		return height - padY - (height-3*padY)*(v-lo)/(hi-lo)
+	}
+
+	// Axes and labels
+	fmt.Fprintf(&b, `<line x1="%.0f" y1="%.0f" x2="%.0f" y2="%.0f" stroke="#999"/>`, padX, height-padY, width-padX, height-padY)
+	fmt.Fprintf(&b, `<line x1="%.0f" y1="%.0f" x2="%.0f" y2="%.0f" stroke="#999"/>`, padX, 2*padY, padX, height-padY)
+	fmt.Fprintf(&b, `<text x="2" y="%.1f">%.1f</text>`, y(hi)+4, hi)
+	fmt.Fprintf(&b, `<text x="2" y="%.1f">%.1f</text>`, y(lo)+4, lo)
+	fmt.Fprintf(&b, `<text x="%.0f" y="%.0f">%s</text>`, padX, height-4, from.In(tz).Format("2006-01-02"))
+	fmt.Fprintf(&b, `<text x="%.0f" y="%.0f" text-anchor="end">%s</text>`, width-padX, height-4, to.In(tz).Add(-time.Second).Format("2006-01-02"))
+
+	coords := make([]string, len(points))
+	for i, p := range points {
+		coords[i] = fmt.Sprintf("%.1f,%.1f", x(p.Time), y(p.Value))
+	}
+	fmt.Fprintf(&b, `<polyline fill="none" stroke="#1f77b4" stroke-width="2" points="%s"/>`, strings.Join(coords, " "))
+	for _, c := range coords {
+		xy := strings.Split(c, ",")
+		fmt.Fprintf(&b, `<circle cx="%s" cy="%s" r="2.5" fill="#1f77b4"/>`, xy[0], xy[1])
+	}
+
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+var reportFuncs = template.FuncMap{
+	"date": func(t time.Time) string { return t.In(tz).Format("2006-01-02") },
+	"lastDate": func(t time.Time) string {
+		return t.In(tz).Add(-time.Second).Format("2006-01-02")
+	},
+	"num":    func(f float64) string { return fmt.Sprintf("%.2f", f) },
+	"signed": func(f float64) string { return fmt.Sprintf("%+.2f", f) },
+	"change": func(f *float64) string {
+		if f == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%+.2f", *f)
+	},
+}
+
+var reportMarkdownTemplate = template.Must(template.New("markdown").Funcs(reportFuncs).Parse(`# Progress report ({{.Report.Period}}: {{date .Report.From}} - {{lastDate .Report.To}})
+
+| Metric | Count | Min | Max | Mean | Trend (/week) | Change from previous {{.Report.Period}} |
+| --- | ---: | ---: | ---: | ---: | ---: | ---: |
+{{range .Report.Metrics}}{{if .Count}}| {{.Name}} ({{.Unit}}) | {{.Count}} | {{num .Min}} | {{num .Max}} | {{num .Mean}} | {{signed .SlopePerWeek}} | {{change .Change}} |
+{{else}}| {{.Name}} ({{.Unit}}) | 0 | - | - | - | - | - |
+{{end}}{{end}}
+{{range $i, $m := .Report.Metrics}}![{{$m.Name}}](data:image/svg+xml;base64,{{index $.Charts $i}})
+
+{{end}}`))
+
+var reportHTMLTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap(reportFuncs)).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Progress report ({{.Report.Period}}: {{date .Report.From}} - {{lastDate .Report.To}})</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>Progress report ({{.Report.Period}}: {{date .Report.From}} - {{lastDate .Report.To}})</h1>
+<table>
+<tr><th>Metric</th><th>Count</th><th>Min</th><th>Max</th><th>Mean</th><th>Trend (/week)</th><th>Change from previous {{.Report.Period}}</th></tr>
+{{range .Report.Metrics}}{{if .Count}}<tr><td>{{.Name}} ({{.Unit}})</td><td>{{.Count}}</td><td>{{num .Min}}</td><td>{{num .Max}}</td><td>{{num .Mean}}</td><td>{{signed .SlopePerWeek}}</td><td>{{change .Change}}</td></tr>
+{{else}}<tr><td>{{.Name}} ({{.Unit}})</td><td>0</td><td>-</td><td>-</td><td>-</td><td>-</td><td>-</td></tr>
+{{end}}{{end}}</table>
+{{range .Charts}}<p>{{.}}</p>
+{{end}}</body>
+</html>
+`))
+
+// WriteReport renders the report as Markdown or HTML with inline SVG charts.
+func WriteReport(w io.Writer, format string, r Report) error {
+	switch format {
+	case ReportFormatMarkdown:
+		charts := make([]string, len(r.Metrics))
+		for i, m := range r.Metrics {
+			charts[i] = base64.StdEncoding.EncodeToString([]byte(SVGChart(m.Name, m.Unit, m.Points, r.From, r.To)))
+		}
+		var buf bytes.Buffer
+		if err := reportMarkdownTemplate.Execute(&buf, map[string]interface{}{"Report": r, "Charts": charts}); err != nil {
+			return errors.Wrap(err, "failed to render report")
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	case ReportFormatHTML:
+		charts := make([]htmltemplate.HTML, len(r.Metrics))
+		for i, m := range r.Metrics {
+			charts[i] = htmltemplate.HTML(SVGChart(m.Name, m.Unit, m.Points, r.From, r.To))
+		}
+		var buf bytes.Buffer
+		if err := reportHTMLTemplate.Execute(&buf, map[string]interface{}{"Report": r, "Charts": charts}); err != nil {
+			return errors.Wrap(err, "failed to render report")
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	default:
+		return errors.Errorf("unknown report format: %s", format)
+	}
+}
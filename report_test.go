@@ -0,0 +1,107 @@
+package htf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportPeriodRange(t *testing.T) {
+	// 2023-01-05 is a Thursday
+	date := time.Date(2023, 1, 5, 12, 0, 0, 0, tz)
+
+	from, to, err := ReportPeriodRange(ReportPeriodWeek, date)
+	if err != nil {
+		t.Fatalf("ReportPeriodRange(week) error = %v", err)
+	}
+	if !from.Equal(time.Date(2023, 1, 2, 0, 0, 0, 0, tz)) || !to.Equal(time.Date(2023, 1, 9, 0, 0, 0, 0, tz)) {
+		t.Errorf("ReportPeriodRange(week) = %v - %v", from, to)
+	}
+
+	from, to, err = ReportPeriodRange(ReportPeriodMonth, date)
+	if err != nil {
+		t.Fatalf("ReportPeriodRange(month) error = %v", err)
+	}
+	if !from.Equal(time.Date(2023, 1, 1, 0, 0, 0, 0, tz)) || !to.Equal(time.Date(2023, 2, 1, 0, 0, 0, 0, tz)) {
+		t.Errorf("ReportPeriodRange(month) = %v - %v", from, to)
+	}
+
+	if _, _, err := ReportPeriodRange("year", date); err == nil {
+		t.Error("ReportPeriodRange(year) error = nil, want error")
+	}
+}
+
+func TestBuildReport(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	from := time.Date(2023, 1, 2, 0, 0, 0, 0, tz)
+	to := from.AddDate(0, 0, 7)
+
+	current := []Measurement{
+		{Time: from.Add(8 * time.Hour), Weight: f(71), Fat: f(20)},
+		{Time: from.AddDate(0, 0, 1).Add(8 * time.Hour), Weight: f(70.5)},
+		{Time: from.AddDate(0, 0, 2).Add(8 * time.Hour), Weight: f(70), Fat: f(21)},
+	}
+	previous := []Measurement{
+		{Time: from.AddDate(0, 0, -3), Weight: f(72)},
+	}
+
+	r := BuildReport(ReportPeriodWeek, from, to, current, previous)
+	if len(r.Metrics) != 4 {
+		t.Fatalf("got %d metrics, want 4", len(r.Metrics))
+	}
+
+	weight := r.Metrics[0]
+	if weight.Count != 3 || weight.Min != 70 || weight.Max != 71 || weight.Mean != 70.5 {
+		t.Errorf("weight stats = %+v", weight)
+	}
+	if math.Abs(weight.SlopePerWeek-(-3.5)) > 1e-9 {
+		t.Errorf("weight slope = %v, want -3.5", weight.SlopePerWeek)
+	}
+	if weight.Change == nil || *weight.Change != -1.5 {
+		t.Errorf("weight change = %v, want -1.5", weight.Change)
+	}
+
+	fat := r.Metrics[1]
+	if fat.Count != 2 || fat.Change != nil {
+		t.Errorf("fat stats = %+v", fat)
+	}
+
+	fatMass, leanMass := r.Metrics[2], r.Metrics[3]
+	if fatMass.Count != 2 || math.Abs(fatMass.Min-14.2) > 1e-9 || math.Abs(fatMass.Max-14.7) > 1e-9 {
+		t.Errorf("fat mass stats = %+v", fatMass)
+	}
+	if math.Abs(leanMass.Points[0].Value-56.8) > 1e-9 || math.Abs(leanMass.Points[1].Value-55.3) > 1e-9 {
+		t.Errorf("lean mass points = %+v", leanMass.Points)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, ReportFormatHTML, r); err != nil {
+		t.Fatalf("WriteReport(html) error = %v", err)
+	}
+	if n := strings.Count(buf.String(), "<svg "); n != 4 {
+		t.Errorf("html report has %d charts, want 4", n)
+	}
+	if !strings.Contains(buf.String(), "<td>Weight (kg)</td><td>3</td><td>70.00</td><td>71.00</td><td>70.50</td><td>-3.50</td><td>-1.50</td>") {
+		t.Errorf("html report does not contain the weight row:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteReport(&buf, ReportFormatMarkdown, r); err != nil {
+		t.Fatalf("WriteReport(markdown) error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "| Weight (kg) | 3 | 70.00 | 71.00 | 70.50 | -3.50 | -1.50 |") {
+		t.Errorf("markdown report does not contain the weight row:\n%s", buf.String())
+	}
+	m := regexp.MustCompile(`data:image/svg\+xml;base64,([A-Za-z0-9+/=]+)`).FindStringSubmatch(buf.String())
+	if m == nil {
+		t.Fatalf("markdown report has no chart:\n%s", buf.String())
+	}
+	svg, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil || !strings.HasPrefix(string(svg), "<svg ") {
+		t.Errorf("invalid chart: %v %s", err, svg)
+	}
+}
@@ -0,0 +1,245 @@
+package htf
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy describes how transient failures are retried: up to
+// MaxAttempts attempts in total, waiting an exponentially growing delay
+// between BaseDelay and MaxDelay, with jitter.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is used for zero fields of a RetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	return p
+}
+
+// Backoff returns the delay before the retry following attempt (1 for the
+// first attempt): BaseDelay doubled for every attempt, capped at MaxDelay,
+// and then reduced by up to half at random so that clients do not retry in
+// lockstep.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	p = p.withDefaults()
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxDelay)
+	return d/2 + rand.N(d/2+1)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-t.C:
+		return nil
+	}
+}
+
+// IsTransient reports whether err is a failure that may not happen again: a
+// network error or a server error. Cancellation and timeouts of the caller's
+// context are not transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if StatusCode(err) != 0 {
+		return errors.Is(err, ErrUpstream)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// retryable reports whether a call made with ctx that failed with err is
+// worth another attempt. A deadline exceeded while ctx is still alive is the
+// timeout of the attempt itself.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return IsTransient(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// RetryTransport retries requests that are safe to repeat (GET and HEAD)
+// after network errors, timeouts of an attempt, server errors and 429 Too
+// Many Requests. A Retry-After header is honored, and a response asking to
+// wait longer than the policy's MaxDelay is returned as is.
+//
+// Other requests are sent once: a failed POST may still have created its
+// entry, so it is retried by RetryCreate, which checks for it first.
+type RetryTransport struct {
+	Base   http.RoundTripper
+	Policy RetryPolicy
+	// AttemptTimeout limits each attempt, including reading its response
+	// body. Zero means no limit. Unlike http.Client.Timeout it does not
+	// include the delays between attempts.
+	AttemptTimeout time.Duration
+
+	// sleep is replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func (t *RetryTransport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.attempt(req)
+	}
+
+	policy := t.Policy.withDefaults()
+	wait := t.sleep
+	if wait == nil {
+		wait = sleep
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := t.attempt(req)
+		if attempt >= policy.MaxAttempts || req.Context().Err() != nil {
+			return res, err
+		}
+
+		var delay time.Duration
+		switch {
+		case err != nil:
+			if !retryable(req.Context(), err) {
+				return res, err
+			}
+			delay = policy.Backoff(attempt)
+		case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+			delay = policy.Backoff(attempt)
+			if after, ok := retryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+				if after > policy.MaxDelay {
+					// Not worth waiting for in this run
+					return res, nil
+				}
+				delay = after
+			}
+		default:
+			return res, nil
+		}
+
+		status := 0
+		if res != nil {
+			status = res.StatusCode
+			// Drain so the connection can be reused
+			_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+			res.Body.Close()
+		}
+		slog.Debug("retrying", "url", req.URL.Host+req.URL.Path, "attempt", attempt, "status_code", status, "delay", delay, "error", err)
+
+		if err := wait(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// attempt sends req once, limited by AttemptTimeout.
+func (t *RetryTransport) attempt(req *http.Request) (*http.Response, error) {
+	if t.AttemptTimeout <= 0 {
+		return t.base().RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.AttemptTimeout)
+	res, err := t.base().RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// cancelBody releases the context of an attempt once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// RetryCreate calls create, retrying transient failures (see IsTransient)
+// and timed out attempts with policy. Since a failed create may still have
+// created the entry, exists is asked before every retry; if the entry is
+// there, RetryCreate returns the zero value and no error.
+func RetryCreate[T any](ctx context.Context, policy RetryPolicy, create func() (T, error), exists func() (bool, error)) (T, error) {
+	policy = policy.withDefaults()
+	var zero T
+	for attempt := 1; ; attempt++ {
+		v, err := create()
+		if err == nil || !retryable(ctx, err) || attempt >= policy.MaxAttempts {
+			return v, err
+		}
+
+		if err := sleep(ctx, policy.Backoff(attempt)); err != nil {
+			return zero, err
+		}
+
+		found, existsErr := exists()
+		if existsErr != nil {
+			return zero, errors.Wrapf(err, "failed to check for a duplicate (%v) after a failed create", existsErr)
+		}
+		if found {
+			slog.Debug("created despite the error", "attempt", attempt, "error", err)
+			return zero, nil
+		}
+		slog.Debug("retrying create", "attempt", attempt, "error", err)
+	}
+}
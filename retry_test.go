@@ -0,0 +1,259 @@
+package htf
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// faultServer answers requests with the next fault in faults, and with 200
+// once they are used up. A zero status drops the connection.
+type faultServer struct {
+	*httptest.Server
+	hits atomic.Int32
+}
+
+type fault struct {
+	status     int
+	retryAfter string
+}
+
+func newFaultServer(t *testing.T, body string, faults ...fault) *faultServer {
+	s := &faultServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(s.hits.Add(1))
+		if n > len(faults) {
+			_, _ = io.WriteString(w, body)
+			return
+		}
+		f := faults[n-1]
+		if f.status == 0 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Fatalf("Hijack() error = %v", err)
+			}
+			conn.Close()
+			return
+		}
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		w.WriteHeader(f.status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// retryClient returns a client retrying without waiting, recording the
+// delays it would have waited.
+func retryClient(policy RetryPolicy, delays *[]time.Duration) *http.Client {
+	return &http.Client{Transport: &RetryTransport{
+		Policy: policy,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			*delays = append(*delays, d)
+			return nil
+		},
+	}}
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		faults     []fault
+		wantStatus int
+		wantHits   int32
+	}{
+		{"server errors", http.MethodGet, []fault{{status: 503}, {status: 500}}, 200, 3},
+		{"dropped connection", http.MethodGet, []fault{{}}, 200, 2},
+		{"exhausted", http.MethodGet, []fault{{status: 502}, {status: 502}, {status: 502}}, 502, 3},
+		{"client error", http.MethodGet, []fault{{status: 404}}, 404, 1},
+		{"post", http.MethodPost, []fault{{status: 503}}, 503, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFaultServer(t, "ok", tt.faults...)
+			var delays []time.Duration
+			client := retryClient(RetryPolicy{MaxAttempts: 3}, &delays)
+
+			req, _ := http.NewRequest(tt.method, s.URL, nil)
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("StatusCode = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if got := s.hits.Load(); got != tt.wantHits {
+				t.Errorf("hits = %d, want %d", got, tt.wantHits)
+			}
+			if len(delays) != int(tt.wantHits)-1 {
+				t.Errorf("delays = %v", delays)
+			}
+		})
+	}
+}
+
+func TestRetryTransport_RetryAfter(t *testing.T) {
+	s := newFaultServer(t, "ok", fault{status: 429, retryAfter: "7"}, fault{status: 503, retryAfter: "120"})
+	var delays []time.Duration
+	client := retryClient(RetryPolicy{MaxAttempts: 5, MaxDelay: time.Minute}, &delays)
+
+	res, err := client.Get(s.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	res.Body.Close()
+
+	// The second response asks to wait longer than MaxDelay
+	if res.StatusCode != 503 {
+		t.Errorf("StatusCode = %d, want 503", res.StatusCode)
+	}
+	if len(delays) != 1 || delays[0] != 7*time.Second {
+		t.Errorf("delays = %v, want [7s]", delays)
+	}
+}
+
+func TestRetryTransport_AttemptTimeout(t *testing.T) {
+	var hits atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer s.Close()
+
+	var delays []time.Duration
+	client := retryClient(RetryPolicy{MaxAttempts: 2}, &delays)
+	client.Transport.(*RetryTransport).AttemptTimeout = 50 * time.Millisecond
+
+	res, err := client.Get(s.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Errorf("body = %q, %v", body, err)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("hits = %d, want 2", hits.Load())
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 5: 10 * time.Second} {
+		for range 20 {
+			if got := p.Backoff(attempt); got < want/2 || got > want {
+				t.Errorf("Backoff(%d) = %v, want in [%v, %v]", attempt, got, want/2, want)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		v    string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, false},
+		{"30", 30 * time.Second, true},
+		{"Sun, 01 Jan 2023 00:01:00 GMT", time.Minute, true},
+		{"Sat, 31 Dec 2022 23:59:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := retryAfter(tt.v, now); got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v, want %v, %v", tt.v, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// hostTransport sends every request to the test server at host.
+type hostTransport struct {
+	host string
+}
+
+func (t hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = t.host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestRetryCreate(t *testing.T) {
+	// Fitbit stores the log but the response is lost
+	var created, lists atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			created.Add(1)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		lists.Add(1)
+		_, _ = io.WriteString(w, `{"weight": [{"date": "2023-01-01", "time": "03:00:00", "weight": 70, "logId": 1}]}`)
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	api := &FitbitAPI{Client: &http.Client{Transport: hostTransport{host: u.Host}}}
+	ctx := context.Background()
+	ts := time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC)
+	weight := 70.0
+	m := Measurement{Time: ts, Weight: &weight}
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	id, err := RetryCreate(ctx, policy, func() (string, error) {
+		return api.WriteMetric(ctx, MetricWeight, m)
+	}, func() (bool, error) {
+		return api.HasMetric(ctx, AggregatePolicyAll, MetricWeight, ts)
+	})
+	if err != nil {
+		t.Fatalf("RetryCreate() error = %v", err)
+	}
+	if id != "" {
+		t.Errorf("id = %q, want empty", id)
+	}
+	if created.Load() != 1 || lists.Load() != 1 {
+		t.Errorf("created = %d, lists = %d, want 1, 1", created.Load(), lists.Load())
+	}
+}
+
+func TestRetryCreate_Retries(t *testing.T) {
+	var calls int
+	errUpstream := &APIError{Provider: "fitbit", StatusCode: 503}
+	v, err := RetryCreate(context.Background(), RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}, func() (int, error) {
+		calls++
+		if calls < 3 {
+			return 0, errUpstream
+		}
+		return 42, nil
+	}, func() (bool, error) { return false, nil })
+	if err != nil || v != 42 || calls != 3 {
+		t.Errorf("RetryCreate() = %d, %v after %d calls", v, err, calls)
+	}
+
+	calls = 0
+	errBad := &APIError{Provider: "fitbit", StatusCode: 400}
+	_, err = RetryCreate(context.Background(), RetryPolicy{MaxAttempts: 3}, func() (int, error) {
+		calls++
+		return 0, errBad
+	}, func() (bool, error) { return false, nil })
+	if !errors.Is(err, errBad) || calls != 1 {
+		t.Errorf("RetryCreate() error = %v after %d calls", err, calls)
+	}
+}
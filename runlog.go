@@ -0,0 +1,79 @@
+package htf
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RunLog is an append-only JSON Lines file of sync run summaries.
+type RunLog struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (l *RunLog) Append(s SyncSummary) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0700); err != nil {
+		return errors.Wrap(err, "failed to create directory")
+	}
+
+	f, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open run log")
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(s); err != nil {
+		return errors.Wrap(err, "failed to write run log")
+	}
+
+	return nil
+}
+
+// Recent returns the last n runs, newest first.
+func (l *RunLog) Recent(n int) ([]SyncSummary, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to open run log")
+	}
+	defer f.Close()
+
+	var runs []SyncSummary
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var s SyncSummary
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			return nil, errors.Wrap(err, "failed to parse run log")
+		}
+		runs = append(runs, s)
+		if len(runs) > n {
+			runs = runs[1:]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read run log")
+	}
+
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+
+	return runs, nil
+}
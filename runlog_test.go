@@ -0,0 +1,29 @@
+package htf
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRunLog(t *testing.T) {
+	l := &RunLog{Path: filepath.Join(t.TempDir(), "runs.jsonl")}
+
+	runs, err := l.Recent(2)
+	if err != nil || len(runs) != 0 {
+		t.Fatalf("Recent() on missing file = %v, %v", runs, err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err := l.Append(SyncSummary{Event: NotifyEventSuccess, Created: i}); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	runs, err = l.Recent(2)
+	if err != nil {
+		t.Fatalf("Recent() error = %v", err)
+	}
+	if len(runs) != 2 || runs[0].Created != 3 || runs[1].Created != 2 {
+		t.Errorf("Recent() = %+v, want the last two runs newest first", runs)
+	}
+}
@@ -0,0 +1,118 @@
+package htf
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Measurement is a body composition record as stored in a Sink.
+type Measurement struct {
+	Time   time.Time `json:"time"`
+	Weight *float64  `json:"weight,omitempty"`
+	Fat    *float64  `json:"fat,omitempty"`
+	// WeightTrend is the smoothed weight, if computed.
+	WeightTrend *float64 `json:"weight_trend,omitempty"`
+	// BMI, FatMass (kg) and LeanMass (kg) are derived from the readings.
+	BMI      *float64 `json:"bmi,omitempty"`
+	FatMass  *float64 `json:"fat_mass,omitempty"`
+	LeanMass *float64 `json:"lean_mass,omitempty"`
+	// ID is the identifier assigned by the sink, if any.
+	ID string `json:"id,omitempty"`
+}
+
+// measurementFieldNames are the names of the values of a Measurement in the
+// file and InfluxDB sinks, in the order of Measurement.fields.
+var measurementFieldNames = []string{"weight", "fat", "weight_trend", "bmi", "fat_mass", "lean_mass"}
+
+func (m *Measurement) fields() []**float64 {
+	return []**float64{&m.Weight, &m.Fat, &m.WeightTrend, &m.BMI, &m.FatMass, &m.LeanMass}
+}
+
+// Metrics measured by the scale, as opposed to derived ones.
+const (
+	MetricWeight = "weight"
+	MetricFat    = "fat"
+)
+
+// Metrics returns the measured metrics m has a value for.
+func (m Measurement) Metrics() []string {
+	var metrics []string
+	if m.Weight != nil {
+		metrics = append(metrics, MetricWeight)
+	}
+	if m.Fat != nil {
+		metrics = append(metrics, MetricFat)
+	}
+	return metrics
+}
+
+// Sink is a destination HealthPlanet measurements can be written to.
+type Sink interface {
+	Name() string
+	WriteMeasurement(ctx context.Context, m Measurement) error
+	ListMeasurements(ctx context.Context, from, to time.Time) ([]Measurement, error)
+	DeleteMeasurement(ctx context.Context, m Measurement) error
+}
+
+// MetricSink is implemented by sinks that store each metric of a reading
+// separately, such as Fitbit, so a reading can be partly written. The
+// Syncer then checks and writes the metrics one by one.
+type MetricSink interface {
+	Sink
+	// HasMetric reports whether the sink has metric for the reading at t
+	// (see DailyKey for policy).
+	HasMetric(ctx context.Context, policy, metric string, t time.Time) (bool, error)
+	// WriteMetric writes metric of m and returns the id of the new entry.
+	WriteMetric(ctx context.Context, metric string, m Measurement) (string, error)
+	// DeleteMetric deletes the entry of metric with the id WriteMetric
+	// returned.
+	DeleteMetric(ctx context.Context, metric, id string) error
+}
+
+// MeasurementUpdater is implemented by sinks that can replace measurements
+// they hold in place, matched by time.
+type MeasurementUpdater interface {
+	UpdateMeasurements(ctx context.Context, ms []Measurement) error
+}
+
+// SyncSink writes every measurement in data that the sink does not have yet
+// and returns the number of measurements written.
+func SyncSink(ctx context.Context, sink Sink, data AggregatedInnerScanDataMap) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	var from, to time.Time
+	for t := range data {
+		if from.IsZero() || t.Before(from) {
+			from = t
+		}
+		if to.IsZero() || t.After(to) {
+			to = t
+		}
+	}
+
+	existing, err := sink.ListMeasurements(ctx, from, to)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to list measurements in %s", sink.Name())
+	}
+	found := make(map[int64]bool, len(existing))
+	for _, m := range existing {
+		found[m.Time.Unix()] = true
+	}
+
+	created := 0
+	for t, d := range data {
+		if found[t.Unix()] {
+			continue
+		}
+		if err := sink.WriteMeasurement(ctx, d.Measurement(t)); err != nil {
+			return created, errors.Wrapf(err, "failed to write measurement to %s", sink.Name())
+		}
+		created++
+	}
+
+	return created, nil
+}
@@ -0,0 +1,133 @@
+package htf
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tags of the sphygmomanometer endpoint.
+const (
+	SphygmomanometerTagSystolic  = "622E"
+	SphygmomanometerTagDiastolic = "622F"
+	SphygmomanometerTagPulse     = "6230"
+)
+
+var sphygmomanometerTags = []string{SphygmomanometerTagSystolic, SphygmomanometerTagDiastolic, SphygmomanometerTagPulse}
+
+// BloodPressureData is a blood pressure reading: pressures in mmHg and the
+// pulse in beats per minute.
+type BloodPressureData struct {
+	Systolic  *float64
+	Diastolic *float64
+	Pulse     *float64
+	Model     string
+	Profile   *InnerScanProfile
+}
+
+type BloodPressureDataMap map[time.Time]*BloodPressureData
+
+// GetSphygmomanometer returns the systolic, diastolic and pulse readings
+// between from and to (YYYYMMDDHHMMSS). An empty from returns the last
+// 3 months.
+func (api *HealthPlanetAPI) GetSphygmomanometer(ctx context.Context, from, to string) (InnerScanResponse, error) {
+	return api.getStatus(ctx, "sphygmomanometer", "sphygmomanometer", strings.Join(sphygmomanometerTags, ","), api.DateMode, from, to)
+}
+
+// AggregateBloodPressureData fetches the blood pressure readings in 3-month
+// chunks and merges the tags of each reading.
+func (api *HealthPlanetAPI) AggregateBloodPressureData(ctx context.Context, from, to string) (BloodPressureDataMap, error) {
+	chunks, err := healthPlanetChunks(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	m := BloodPressureDataMap{}
+	for _, c := range chunks {
+		res, err := api.GetSphygmomanometer(ctx, c.from, c.to)
+		if err != nil {
+			return nil, err
+		}
+		profile := res.InnerScanProfile
+
+		for _, data := range res.Data {
+			t, err := data.Time()
+			if err != nil {
+				slog.Warn("invalid time", "date", data.Date, "metric", data.Tag, "error", err)
+				continue
+			}
+
+			v, err := strconv.ParseFloat(data.KeyData, 64)
+			if err != nil {
+				slog.Warn("invalid value", "date", data.Date, "metric", data.Tag, "value", data.KeyData, "error", err)
+				continue
+			}
+
+			d, ok := m[t]
+			if !ok {
+				d = &BloodPressureData{Model: data.Model, Profile: &profile}
+				m[t] = d
+			}
+			switch strings.ToUpper(data.Tag) {
+			case SphygmomanometerTagSystolic:
+				d.Systolic = &v
+			case SphygmomanometerTagDiastolic:
+				d.Diastolic = &v
+			case SphygmomanometerTagPulse:
+				d.Pulse = &v
+			default:
+				slog.Warn("unknown tag", "date", data.Date, "metric", data.Tag)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// BloodPressureExportRecords flattens the readings into one record per tag,
+// ordered by time.
+func BloodPressureExportRecords(data BloodPressureDataMap) []ExportRecord {
+	times := make([]time.Time, 0, len(data))
+	for t := range data {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	var records []ExportRecord
+	for _, t := range times {
+		d := data[t]
+		base := ExportRecord{
+			Model:     d.Model,
+			LocalTime: t.In(tz).Format(time.RFC3339),
+			UTCTime:   t.UTC().Format(time.RFC3339),
+		}
+		if d.Profile != nil {
+			base.Sex = d.Profile.Sex
+			base.Height = d.Profile.Height
+			base.BirthDate = d.Profile.BirthDate
+		}
+
+		for _, v := range []struct {
+			tag   string
+			value *float64
+			unit  string
+		}{
+			{SphygmomanometerTagSystolic, d.Systolic, "mmHg"},
+			{SphygmomanometerTagDiastolic, d.Diastolic, "mmHg"},
+			{SphygmomanometerTagPulse, d.Pulse, "bpm"},
+		} {
+			if v.value != nil {
+				r := base
+				r.Tag = v.tag
+				r.Value = *v.value
+				r.Unit = v.unit
+				records = append(records, r)
+			}
+		}
+	}
+
+	return records
+}
@@ -0,0 +1,77 @@
+package htf
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHealthPlanetAPI_AggregateBloodPressureData(t *testing.T) {
+	resp := `{
+		"birth_date": "19900101",
+		"height": "170",
+		"sex": "male",
+		"data": [
+			{"date": "202301010700", "keydata": "121", "model": "bp", "tag": "622E"},
+			{"date": "202301010700", "keydata": "79", "model": "bp", "tag": "622F"},
+			{"date": "202301010700", "keydata": "64", "model": "bp", "tag": "6230"},
+			{"date": "202301020700", "keydata": "118", "model": "bp", "tag": "622E"}
+		]
+	}`
+
+	var requests []*http.Request
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		requests = append(requests, req)
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewBufferString(resp)),
+			Header:     make(http.Header),
+		}
+	})
+	api := &HealthPlanetAPI{AccessToken: "test_token", Client: client}
+
+	got, err := api.AggregateBloodPressureData(context.Background(), "", "")
+	if err != nil {
+		t.Fatalf("AggregateBloodPressureData() error = %v", err)
+	}
+
+	if len(requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(requests))
+	}
+	if requests[0].URL.Path != "/status/sphygmomanometer.json" {
+		t.Errorf("path = %s", requests[0].URL.Path)
+	}
+	if tag := requests[0].URL.Query().Get("tag"); tag != "622E,622F,6230" {
+		t.Errorf("tag = %s", tag)
+	}
+
+	t1 := time.Date(2023, 1, 1, 7, 0, 0, 0, tz).UTC()
+	d, ok := got[t1]
+	if !ok {
+		t.Fatalf("reading at %s not found", t1)
+	}
+	if *d.Systolic != 121 || *d.Diastolic != 79 || *d.Pulse != 64 {
+		t.Errorf("reading = %v/%v/%v", *d.Systolic, *d.Diastolic, *d.Pulse)
+	}
+
+	records := BloodPressureExportRecords(got)
+	if len(records) != 4 {
+		t.Fatalf("BloodPressureExportRecords() = %d records, want 4", len(records))
+	}
+	if r := records[2]; r.Tag != SphygmomanometerTagPulse || r.Unit != "bpm" || r.LocalTime != "2023-01-01T07:00:00+09:00" || r.Height != "170" {
+		t.Errorf("records[2] = %+v", r)
+	}
+}
+
+func TestHealthPlanetChunks(t *testing.T) {
+	chunks, err := healthPlanetChunks("20230101000000", "20230801000000")
+	if err != nil {
+		t.Fatalf("healthPlanetChunks() error = %v", err)
+	}
+	if len(chunks) != 3 || chunks[0].to != "20230401000000" || chunks[1].from != "20230401000001" || chunks[2].to != "20230801000000" {
+		t.Errorf("healthPlanetChunks() = %+v", chunks)
+	}
+}
@@ -0,0 +1,213 @@
+package htf
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// StepsSource provides the daily step counts to sync.
+type StepsSource interface {
+	FetchSteps(ctx context.Context) (StepsDataMap, error)
+}
+
+// StepsSourceFunc adapts a function to StepsSource.
+type StepsSourceFunc func(ctx context.Context) (StepsDataMap, error)
+
+func (f StepsSourceFunc) FetchSteps(ctx context.Context) (StepsDataMap, error) {
+	return f(ctx)
+}
+
+// HealthPlanetStepsSource fetches the step counts between From and To
+// (YYYYMMDDHHMMSS, see HealthPlanetDateRange).
+type HealthPlanetStepsSource struct {
+	API      *HealthPlanetAPI
+	From, To string
+}
+
+func (s *HealthPlanetStepsSource) FetchSteps(ctx context.Context) (StepsDataMap, error) {
+	return s.API.AggregateDailySteps(ctx, s.From, s.To)
+}
+
+// StepsSink logs daily step counts as activities. *FitbitAPI implements it.
+type StepsSink interface {
+	HasStepsLog(ctx context.Context, activityId int64, day time.Time) (bool, error)
+	CreateStepsLog(ctx context.Context, activityId int64, day time.Time, steps int) error
+}
+
+// StepsKey returns the cache key of the step count of day.
+func StepsKey(day time.Time) string {
+	return "steps " + day.In(tz).Format("2006-01-02")
+}
+
+// StepsResult is the outcome of a StepsSyncer run.
+type StepsResult struct {
+	// Data is every daily step count fetched from the source.
+	Data StepsDataMap
+	// Days is the outcome of each day, with Key set to its StepsKey.
+	Days    []ReadingResult
+	Created int
+	// SyncedThrough is the latest day such that it and every earlier day
+	// were either logged or deliberately skipped (see
+	// SyncResult.SyncedThrough).
+	SyncedThrough time.Time
+	// Err is the error that stopped the run, if any.
+	Err error
+}
+
+// StepsSyncer logs the daily step counts of a source as activities in a
+// sink. Like Syncer, logged days are cached and days the sink already has
+// are skipped. Today is left out since its count may still grow.
+type StepsSyncer struct {
+	Source StepsSource
+	Sink   StepsSink
+	// ActivityID is the activity steps are logged as. Defaults to
+	// FitbitActivityWalk.
+	ActivityID int64
+	// Cache is optional.
+	Cache Cache
+	// Days are logged in chronological order, or newest first if
+	// NewestFirst is set.
+	NewestFirst bool
+	// Retry retries logs that fail transiently, after checking that the
+	// failed log did not reach the sink (see RetryCreate). Nil means logs
+	// are not retried.
+	Retry *RetryPolicy
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+
+	OnFetched func(data StepsDataMap)
+	OnCreated func(r ReadingResult)
+	OnSkipped func(r ReadingResult)
+	// OnError is called for the error that stops the run.
+	OnError func(r ReadingResult)
+}
+
+func (s *StepsSyncer) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+func (s *StepsSyncer) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+func (s *StepsSyncer) activityID() int64 {
+	if s.ActivityID == 0 {
+		return FitbitActivityWalk
+	}
+	return s.ActivityID
+}
+
+// Run fetches the step counts and logs the days the sink does not have yet.
+// It stops at the first error, which is returned and recorded in the result.
+func (s *StepsSyncer) Run(ctx context.Context) (*StepsResult, error) {
+	result := &StepsResult{}
+	log := s.logger()
+	activityId := s.activityID()
+
+	steps, err := s.Source.FetchSteps(ctx)
+	if err != nil {
+		result.Err = err
+		return result, err
+	}
+	if s.OnFetched != nil {
+		s.OnFetched(steps)
+	}
+	result.Data = steps
+
+	days := steps.Days()
+	defer func() { result.SyncedThrough = syncedThrough(result.Days, days) }()
+
+	order := days
+	if s.NewestFirst {
+		order = slices.Clone(days)
+		slices.Reverse(order)
+	}
+
+	today := startOfDay(s.now())
+	for _, day := range order {
+		if err := ctx.Err(); err != nil {
+			// Interrupted; the days handled so far are in the result
+			result.Err = err
+			return result, err
+		}
+
+		r := ReadingResult{Time: day, Key: StepsKey(day)}
+		dayJST := day.In(tz)
+		count := steps[day]
+
+		skip := func(outcome, msg string) {
+			r.Outcome = outcome
+			log.Info(msg, "timestamp", dayJST, "metric", "steps", "action", outcome, "provider", "fitbit")
+			result.Days = append(result.Days, r)
+			if s.OnSkipped != nil {
+				s.OnSkipped(r)
+			}
+		}
+		fail := func(err error) error {
+			r.Outcome, r.Err = OutcomeFailed, err
+			result.Days = append(result.Days, r)
+			result.Err = err
+			if s.OnError != nil {
+				s.OnError(r)
+			}
+			return err
+		}
+
+		if !day.Before(today) {
+			skip(OutcomeSkippedIncomplete, "skipped incomplete day")
+			continue
+		}
+
+		if s.Cache != nil && s.Cache.Has(r.Key) {
+			skip(OutcomeSkippedCache, "skipped from cache")
+			continue
+		}
+
+		exists, err := s.Sink.HasStepsLog(ctx, activityId, day)
+		if err != nil {
+			log.Error("failed to get activity log", "timestamp", dayJST, "metric", "steps", "provider", "fitbit", "status_code", StatusCode(err), "error", err)
+			return result, fail(err)
+		}
+		if exists {
+			if s.Cache != nil {
+				s.Cache.Add(r.Key)
+			}
+			skip(OutcomeSkippedExisting, "record is found")
+			continue
+		}
+
+		// A failed create may still have logged the steps; check before retrying
+		_, err = retryCreate(ctx, s.Retry, func() (struct{}, error) {
+			return struct{}{}, s.Sink.CreateStepsLog(ctx, activityId, day, count)
+		}, func() (bool, error) {
+			return s.Sink.HasStepsLog(ctx, activityId, day)
+		})
+		if err != nil {
+			log.Error("failed to save", "timestamp", dayJST, "metric", "steps", "value", count, "action", "create", "provider", "fitbit", "status_code", StatusCode(err), "error", err)
+			return result, fail(err)
+		}
+
+		log.Info("saved", "timestamp", dayJST, "metric", "steps", "value", count, "action", OutcomeCreated, "provider", "fitbit")
+		if s.Cache != nil {
+			s.Cache.Add(r.Key)
+		}
+		r.Outcome = OutcomeCreated
+		result.Days = append(result.Days, r)
+		result.Created++
+		if s.OnCreated != nil {
+			s.OnCreated(r)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,164 @@
+package htf
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// stepsSink keeps step logs in memory and fails the logs of the days in fail.
+type stepsSink struct {
+	logs   map[time.Time]int
+	fail   map[time.Time]error
+	writes []time.Time
+}
+
+func (s *stepsSink) HasStepsLog(ctx context.Context, activityId int64, day time.Time) (bool, error) {
+	_, ok := s.logs[day]
+	return ok, nil
+}
+
+func (s *stepsSink) CreateStepsLog(ctx context.Context, activityId int64, day time.Time, steps int) error {
+	s.writes = append(s.writes, day)
+	if err := s.fail[day]; err != nil {
+		return err
+	}
+	s.logs[day] = steps
+	return nil
+}
+
+func TestStepsSyncer(t *testing.T) {
+	d1 := time.Date(2023, 1, 1, 0, 0, 0, 0, tz)
+	d2 := d1.AddDate(0, 0, 1)
+	d3 := d1.AddDate(0, 0, 2)
+	d4 := d1.AddDate(0, 0, 3)
+	source := StepsSourceFunc(func(ctx context.Context) (StepsDataMap, error) {
+		return StepsDataMap{d1: 8000, d2: 9000, d3: 10000, d4: 1200}, nil
+	})
+
+	ctx := context.Background()
+	// d2 is already in the sink, d1 in the cache, d4 is today
+	sink := &stepsSink{logs: map[time.Time]int{d2: 9000}}
+	cache := mapCache{StepsKey(d1): true}
+
+	outcomes := map[time.Time]string{}
+	record := func(r ReadingResult) { outcomes[r.Time] = r.Outcome }
+	fetched := 0
+	syncer := &StepsSyncer{
+		Source:    source,
+		Sink:      sink,
+		Cache:     cache,
+		Now:       func() time.Time { return d4.Add(20 * time.Hour) },
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		OnFetched: func(data StepsDataMap) { fetched = len(data) },
+		OnCreated: record,
+		OnSkipped: record,
+		OnError:   record,
+	}
+
+	result, err := syncer.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if fetched != 4 || result.Created != 1 || len(result.Days) != 4 {
+		t.Errorf("fetched = %d, Created = %d, Days = %d", fetched, result.Created, len(result.Days))
+	}
+	want := map[time.Time]string{
+		d1: OutcomeSkippedCache,
+		d2: OutcomeSkippedExisting,
+		d3: OutcomeCreated,
+		d4: OutcomeSkippedIncomplete,
+	}
+	for k, v := range want {
+		if outcomes[k] != v {
+			t.Errorf("outcome at %s = %q, want %q", k, outcomes[k], v)
+		}
+	}
+	if sink.logs[d3] != 10000 || !slices.Equal(sink.writes, []time.Time{d3}) {
+		t.Errorf("logs = %v, writes = %v", sink.logs, sink.writes)
+	}
+	// Today is not synced, so the watermark stops before it
+	if !result.SyncedThrough.Equal(d3) {
+		t.Errorf("SyncedThrough = %v, want %v", result.SyncedThrough, d3)
+	}
+	if !cache.Has(StepsKey(d2)) || !cache.Has(StepsKey(d3)) || cache.Has(StepsKey(d4)) {
+		t.Errorf("cache = %v", cache)
+	}
+
+	// A failure stops the run
+	serverError := &APIError{Provider: "test", StatusCode: http.StatusServiceUnavailable, msg: "unavailable"}
+	cache = mapCache{}
+	syncer.Cache = cache
+	sink.logs = map[time.Time]int{}
+	sink.fail = map[time.Time]error{d2: serverError}
+	result, err = syncer.Run(ctx)
+	if !errors.Is(err, ErrUpstream) || result.Err != err {
+		t.Fatalf("Run() error = %v, result.Err = %v", err, result.Err)
+	}
+	if outcomes[d2] != OutcomeFailed || !result.SyncedThrough.Equal(d1) || result.Created != 1 {
+		t.Errorf("outcome = %q, SyncedThrough = %v, Created = %d", outcomes[d2], result.SyncedThrough, result.Created)
+	}
+
+	// Newest first stops at d2 without settling d1, so the watermark stays
+	sink.logs = map[time.Time]int{}
+	syncer.Cache = mapCache{}
+	syncer.NewestFirst = true
+	result, _ = syncer.Run(ctx)
+	if result.Created != 1 || !result.SyncedThrough.IsZero() {
+		t.Errorf("Created = %d, SyncedThrough = %v", result.Created, result.SyncedThrough)
+	}
+	syncer.NewestFirst = false
+
+	// A source failure leaves no data
+	syncer.Source = StepsSourceFunc(func(ctx context.Context) (StepsDataMap, error) {
+		return nil, serverError
+	})
+	result, err = syncer.Run(ctx)
+	if err == nil || result.Data != nil {
+		t.Errorf("Run() error = %v, Data = %v", err, result.Data)
+	}
+}
+
+func TestStepsSyncer_Retry(t *testing.T) {
+	day := time.Date(2023, 1, 1, 0, 0, 0, 0, tz)
+	source := StepsSourceFunc(func(ctx context.Context) (StepsDataMap, error) {
+		return StepsDataMap{day: 8000}, nil
+	})
+	timeout := &APIError{Provider: "test", StatusCode: http.StatusGatewayTimeout, msg: "timeout"}
+
+	// The first log reaches the sink although it times out
+	sink := &lostResponseSink{stepsSink: &stepsSink{logs: map[time.Time]int{}}, err: timeout}
+	syncer := &StepsSyncer{
+		Source: source,
+		Sink:   sink,
+		Retry:  &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	result, err := syncer.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(sink.writes) != 1 || result.Created != 1 {
+		t.Errorf("writes = %v, Created = %d, want a single log", sink.writes, result.Created)
+	}
+}
+
+// lostResponseSink logs the steps but returns err the first time.
+type lostResponseSink struct {
+	*stepsSink
+	err error
+}
+
+func (s *lostResponseSink) CreateStepsLog(ctx context.Context, activityId int64, day time.Time, steps int) error {
+	if err := s.stepsSink.CreateStepsLog(ctx, activityId, day, steps); err != nil {
+		return err
+	}
+	err := s.err
+	s.err = nil
+	return err
+}
@@ -0,0 +1,527 @@
+package htf
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Outcomes of a reading in a SyncResult.
+const (
+	OutcomeCreated           = "created"
+	OutcomeSkippedCache      = "skipped_cache"
+	OutcomeSkippedExisting   = "skipped_existing"
+	OutcomeSkippedQuarantine = "skipped_quarantine"
+	OutcomeSkippedIncomplete = "skipped_incomplete"
+	OutcomeRejected          = "rejected"
+	OutcomeFailed            = "failed"
+	// OutcomeRolledBack is the outcome of a metric that was written and then
+	// deleted because the rest of its reading could not be (see
+	// Syncer.Rollback).
+	OutcomeRolledBack = "rolled_back"
+)
+
+// Source provides the readings to sync.
+type Source interface {
+	Fetch(ctx context.Context) (AggregatedInnerScanDataMap, error)
+}
+
+// SourceFunc adapts a function to Source.
+type SourceFunc func(ctx context.Context) (AggregatedInnerScanDataMap, error)
+
+func (f SourceFunc) Fetch(ctx context.Context) (AggregatedInnerScanDataMap, error) {
+	return f(ctx)
+}
+
+// HealthPlanetSource fetches the InnerScan readings between From and To
+// (YYYYMMDDHHMMSS, see HealthPlanetDateRange).
+type HealthPlanetSource struct {
+	API      *HealthPlanetAPI
+	From, To string
+	// WholeDays fetches, in registration mode, the other readings of the
+	// days of the fetched readings too, by measurement date. Aggregation
+	// policies other than AggregatePolicyAll need them, since a reading
+	// registered late shares its day with readings registered before.
+	WholeDays bool
+}
+
+func (s *HealthPlanetSource) Fetch(ctx context.Context) (AggregatedInnerScanDataMap, error) {
+	data, err := s.API.AggregateInnerScanData(ctx, s.From, s.To)
+	if err != nil || !s.WholeDays || s.API.DateMode != DateModeRegistration || len(data) == 0 {
+		return data, err
+	}
+
+	days := map[time.Time]bool{}
+	for t := range data {
+		days[startOfDay(t)] = true
+	}
+	times := data.Times()
+	first, last := startOfDay(times[0]), startOfDay(times[len(times)-1])
+
+	measured := &HealthPlanetAPI{AccessToken: s.API.AccessToken, Client: s.API.Client, DateMode: DateModeMeasurement}
+	whole, err := measured.AggregateInnerScanData(ctx, first.Format("20060102")+"000000", last.Format("20060102")+"235959")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch the whole days of registered readings")
+	}
+	for t, d := range whole {
+		if _, ok := data[t]; !ok && days[startOfDay(t)] {
+			data[t] = d
+		}
+	}
+	return data, nil
+}
+
+// Cache records the keys of readings that are known to be in the sink.
+// *config.Cache implements it.
+type Cache interface {
+	Has(key string) bool
+	Add(key string)
+}
+
+// KeySet is a set of reading keys. *config.Quarantine implements it.
+type KeySet interface {
+	Has(key string) bool
+}
+
+// ExistenceChecker is implemented by sinks that can check for a single
+// reading more cheaply than by listing measurements.
+type ExistenceChecker interface {
+	HasMeasurement(ctx context.Context, policy string, t time.Time) (bool, error)
+}
+
+// HasMeasurement implements ExistenceChecker (see HasWeightLog).
+func (api *FitbitAPI) HasMeasurement(ctx context.Context, policy string, t time.Time) (bool, error) {
+	return api.HasWeightLog(ctx, policy, t)
+}
+
+// HasMetric implements MetricSink (see HasWeightLog).
+func (api *FitbitAPI) HasMetric(ctx context.Context, policy, metric string, t time.Time) (bool, error) {
+	switch metric {
+	case MetricWeight:
+		return api.HasWeightLog(ctx, policy, t)
+	case MetricFat:
+		return api.HasFatLog(ctx, policy, t)
+	default:
+		return false, errors.Errorf("unknown metric: %s", metric)
+	}
+}
+
+// MetricKey returns the cache key of one metric of the reading with key.
+func MetricKey(key, metric string) string {
+	return key + " " + metric
+}
+
+// ReadingResult is the outcome of one reading.
+type ReadingResult struct {
+	Time time.Time
+	// Key identifies the reading in the cache and quarantine (see DailyKey).
+	Key     string
+	Data    *AggregatedInnerScanData
+	Outcome string
+	// Metrics is the outcome of each metric, for sinks that implement
+	// MetricSink.
+	Metrics map[string]string
+	Err     error
+}
+
+// SyncResult is the outcome of a Syncer run.
+type SyncResult struct {
+	// Data is every reading fetched from the source, before aggregation.
+	Data     AggregatedInnerScanDataMap
+	Readings []ReadingResult
+	Created  int
+	// SyncedThrough is the time of the latest reading such that it and every
+	// earlier reading were either written or deliberately skipped. It is
+	// zero if the earliest reading was not.
+	SyncedThrough time.Time
+	// Err is the error that stopped the run, if any.
+	Err error
+}
+
+// settled reports whether a reading with outcome needs no further run.
+func settled(outcome string) bool {
+	switch outcome {
+	case OutcomeCreated, OutcomeSkippedCache, OutcomeSkippedExisting, OutcomeSkippedQuarantine, OutcomeRejected:
+		return true
+	}
+	return false
+}
+
+// Complete reports whether the run finished and every reading was written
+// or deliberately skipped, so none of them needs another run.
+func (r *SyncResult) Complete() bool {
+	if r.Err != nil || r.Data == nil {
+		return false
+	}
+	for _, reading := range r.Readings {
+		if !settled(reading.Outcome) {
+			return false
+		}
+	}
+	return true
+}
+
+// syncedThrough computes SyncedThrough from the readings handled so far and
+// the times of all readings, in chronological order.
+func syncedThrough(readings []ReadingResult, times []time.Time) time.Time {
+	outcomes := make(map[time.Time]string, len(readings))
+	for _, reading := range readings {
+		outcomes[reading.Time] = reading.Outcome
+	}
+
+	var through time.Time
+	for _, t := range times {
+		if !settled(outcomes[t]) {
+			break
+		}
+		through = t
+	}
+	return through
+}
+
+// Syncer copies readings from a source to a sink, skipping readings that are
+// cached or already in the sink.
+type Syncer struct {
+	Source Source
+	Sink   Sink
+	// Cache and Quarantine are optional. Readings in Quarantine are skipped.
+	Cache      Cache
+	Quarantine KeySet
+	// Policy is the daily aggregation policy (see AggregateDaily).
+	Policy string
+	// Readings are written in chronological order, or newest first if
+	// NewestFirst is set, which gets recent readings in first when
+	// backfilling a long period.
+	NewestFirst bool
+	// Rollback deletes the metrics written for a reading when the rest of
+	// the reading cannot be written, so a reading is either complete or
+	// absent. Otherwise the written metrics are cached and only the missing
+	// ones are retried. It applies to sinks that implement MetricSink.
+	Rollback bool
+	// Retry retries writes that fail transiently, after checking that the
+	// failed write did not reach the sink (see RetryCreate). Nil means
+	// writes are not retried.
+	Retry *RetryPolicy
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+	// IsRejected reports whether a write error means the sink refused the
+	// reading itself. Rejected readings do not stop the run. Defaults to
+	// IsRejected.
+	IsRejected func(err error) bool
+
+	// OnFetched is called with the fetched readings before they are
+	// aggregated and may modify them.
+	OnFetched func(data AggregatedInnerScanDataMap)
+	OnCreated func(r ReadingResult)
+	OnSkipped func(r ReadingResult)
+	// OnError is called for rejected readings and for the error that stops
+	// the run.
+	OnError func(r ReadingResult)
+}
+
+func (s *Syncer) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+func (s *Syncer) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+// Run fetches the readings and writes the ones the sink does not have yet.
+// It stops at the first error that is not a rejected reading, which is
+// returned and recorded in the result.
+func (s *Syncer) Run(ctx context.Context) (*SyncResult, error) {
+	result := &SyncResult{}
+	log := s.logger()
+	sink := s.Sink.Name()
+
+	data, err := s.Source.Fetch(ctx)
+	if err != nil {
+		result.Err = err
+		return result, err
+	}
+	if s.OnFetched != nil {
+		s.OnFetched(data)
+	}
+	result.Data = data
+
+	sinkData, err := AggregateDaily(data, s.Policy)
+	if err != nil {
+		result.Err = err
+		return result, err
+	}
+
+	times := sinkData.Times()
+	defer func() { result.SyncedThrough = syncedThrough(result.Readings, times) }()
+
+	order := times
+	if s.NewestFirst {
+		order = slices.Clone(times)
+		slices.Reverse(order)
+	}
+
+	now := s.now()
+	for _, t := range order {
+		d := sinkData[t]
+		if err := ctx.Err(); err != nil {
+			// Interrupted; the readings handled so far are in the result
+			result.Err = err
+			return result, err
+		}
+
+		r := ReadingResult{Time: t, Key: DailyKey(s.Policy, t), Data: d}
+		tJST := t.In(tz)
+
+		skip := func(outcome, msg string) {
+			r.Outcome = outcome
+			log.Info(msg, "timestamp", tJST, "action", outcome, "provider", sink)
+			result.Readings = append(result.Readings, r)
+			if s.OnSkipped != nil {
+				s.OnSkipped(r)
+			}
+		}
+		fail := func(outcome string, err error) {
+			r.Outcome, r.Err = outcome, err
+			result.Readings = append(result.Readings, r)
+			if s.OnError != nil {
+				s.OnError(r)
+			}
+		}
+
+		if !DayComplete(s.Policy, t, now) {
+			skip(OutcomeSkippedIncomplete, "skipped incomplete day")
+			continue
+		}
+
+		if s.Cache != nil && s.Cache.Has(r.Key) {
+			skip(OutcomeSkippedCache, "skipped from cache")
+			continue
+		}
+
+		if s.Quarantine != nil && s.Quarantine.Has(r.Key) {
+			skip(OutcomeSkippedQuarantine, "skipped quarantined reading")
+			continue
+		}
+
+		metricSink, byMetric := s.Sink.(MetricSink)
+		var missing []string
+		var exists bool
+		if byMetric {
+			missing, err = s.missingMetrics(ctx, metricSink, &r)
+			exists = err == nil && len(missing) == 0
+		} else {
+			exists, err = s.exists(ctx, t)
+		}
+		if err != nil {
+			log.Error("failed to get existing records", "timestamp", tJST, "provider", sink, "status_code", StatusCode(err), "error", err)
+			fail(OutcomeFailed, err)
+			result.Err = err
+			return result, err
+		}
+		if exists {
+			if s.Cache != nil {
+				s.Cache.Add(r.Key)
+			}
+			skip(OutcomeSkippedExisting, "record is found")
+			continue
+		}
+
+		m := d.Measurement(t)
+		if byMetric {
+			err = s.writeMetrics(ctx, metricSink, &r, missing)
+		} else {
+			err = s.writeMeasurement(ctx, m)
+		}
+		if err != nil {
+			status := StatusCode(err)
+			if s.isRejected(err) {
+				// The sink refused this reading; report it and go on
+				log.Warn("rejected", "timestamp", tJST, "action", OutcomeRejected, "provider", sink, "status_code", status, "error", err)
+				fail(OutcomeRejected, err)
+				continue
+			}
+
+			log.Error("failed to save", "timestamp", tJST, "action", "create", "provider", sink, "status_code", status, "error", err)
+			fail(OutcomeFailed, err)
+			result.Err = err
+			return result, err
+		}
+
+		values := map[string]*float64{MetricWeight: m.Weight, MetricFat: m.Fat}
+		for _, metric := range m.Metrics() {
+			if byMetric && r.Metrics[metric] != OutcomeCreated {
+				continue
+			}
+			log.Info("saved", "timestamp", tJST, "metric", metric, "value", *values[metric], "action", OutcomeCreated, "provider", sink)
+		}
+		if s.Cache != nil {
+			s.Cache.Add(r.Key)
+		}
+		r.Outcome = OutcomeCreated
+		result.Readings = append(result.Readings, r)
+		result.Created++
+		if s.OnCreated != nil {
+			s.OnCreated(r)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Syncer) isRejected(err error) bool {
+	if s.IsRejected == nil {
+		return IsRejected(err)
+	}
+	return s.IsRejected(err)
+}
+
+// missingMetrics returns the metrics of r that the sink does not have yet,
+// skipping the ones cached as written.
+func (s *Syncer) missingMetrics(ctx context.Context, sink MetricSink, r *ReadingResult) ([]string, error) {
+	r.Metrics = map[string]string{}
+	var missing []string
+	for _, metric := range r.Data.Measurement(r.Time).Metrics() {
+		key := MetricKey(r.Key, metric)
+		if s.Cache != nil && s.Cache.Has(key) {
+			r.Metrics[metric] = OutcomeSkippedCache
+			continue
+		}
+
+		exists, err := sink.HasMetric(ctx, s.Policy, metric, r.Time)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			r.Metrics[metric] = OutcomeSkippedExisting
+			if s.Cache != nil {
+				s.Cache.Add(key)
+			}
+			continue
+		}
+		missing = append(missing, metric)
+	}
+	return missing, nil
+}
+
+// writeMetrics writes the missing metrics of r. Each written metric is
+// cached unless s.Rollback is set, in which case the written metrics are
+// deleted again if a later one fails.
+func (s *Syncer) writeMetrics(ctx context.Context, sink MetricSink, r *ReadingResult, missing []string) error {
+	m := r.Data.Measurement(r.Time)
+	written := map[string]string{}
+	for _, metric := range missing {
+		id, err := retryCreate(ctx, s.Retry, func() (string, error) {
+			return sink.WriteMetric(ctx, metric, m)
+		}, func() (bool, error) {
+			return sink.HasMetric(ctx, s.Policy, metric, r.Time)
+		})
+		if err != nil {
+			r.Metrics[metric] = OutcomeFailed
+			if s.Rollback {
+				s.rollback(ctx, sink, r, written)
+			}
+			return err
+		}
+
+		r.Metrics[metric] = OutcomeCreated
+		written[metric] = id
+		if s.Cache != nil && !s.Rollback {
+			s.Cache.Add(MetricKey(r.Key, metric))
+		}
+	}
+	return nil
+}
+
+// writeMeasurement writes m, retrying with s.Retry if it is set.
+func (s *Syncer) writeMeasurement(ctx context.Context, m Measurement) error {
+	_, err := retryCreate(ctx, s.Retry, func() (struct{}, error) {
+		return struct{}{}, s.Sink.WriteMeasurement(ctx, m)
+	}, func() (bool, error) {
+		return s.exists(ctx, m.Time)
+	})
+	return err
+}
+
+// retryCreate is RetryCreate, or a single call to create if policy is nil.
+func retryCreate[T any](ctx context.Context, policy *RetryPolicy, create func() (T, error), exists func() (bool, error)) (T, error) {
+	if policy == nil {
+		return create()
+	}
+	return RetryCreate(ctx, *policy, create, exists)
+}
+
+// rollback deletes the metrics written for r. A metric that cannot be
+// deleted stays in the sink and is cached, so it is not written twice.
+func (s *Syncer) rollback(ctx context.Context, sink MetricSink, r *ReadingResult, written map[string]string) {
+	// Roll back even if the run was interrupted
+	ctx = context.WithoutCancel(ctx)
+	for metric, id := range written {
+		err := errors.New("the id of the written log is unknown")
+		if id != "" {
+			err = sink.DeleteMetric(ctx, metric, id)
+		}
+		if err != nil {
+			s.logger().Error("failed to roll back", "timestamp", r.Time.In(tz), "metric", metric, "provider", sink.Name(), "status_code", StatusCode(err), "error", err)
+			if s.Cache != nil {
+				s.Cache.Add(MetricKey(r.Key, metric))
+			}
+			continue
+		}
+		s.logger().Info("rolled back", "timestamp", r.Time.In(tz), "metric", metric, "action", OutcomeRolledBack, "provider", sink.Name())
+		r.Metrics[metric] = OutcomeRolledBack
+	}
+}
+
+// exists reports whether the sink already has the reading at t, asking the
+// sink directly if it can and listing its measurements otherwise.
+func (s *Syncer) exists(ctx context.Context, t time.Time) (bool, error) {
+	if c, ok := s.Sink.(ExistenceChecker); ok {
+		return c.HasMeasurement(ctx, s.Policy, t)
+	}
+
+	from, to := logWindow(s.Policy, t)
+	ms, err := s.Sink.ListMeasurements(ctx, from, to)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to list measurements in %s", s.Sink.Name())
+	}
+	return len(ms) > 0, nil
+}
+
+// IsRejected reports whether err is a client error about the request itself,
+// as opposed to auth, rate limits or server errors, so retrying the same
+// request cannot succeed.
+func IsRejected(err error) bool {
+	return errors.Is(err, ErrBadRequest)
+}
+
+// HealthPlanetDateRange converts YYYY-MM-DD dates into the HealthPlanet API
+// format (YYYYMMDDHHMMSS). An empty from defaults to 3 months before now, an
+// empty to to the end of today.
+func HealthPlanetDateRange(from, to string, now time.Time) (string, string) {
+	var apiFrom, apiTo string
+	if from != "" {
+		apiFrom = strings.ReplaceAll(from, "-", "") + "000000"
+	} else {
+		apiFrom = now.AddDate(0, -3, 0).Format("20060102") + "000000"
+	}
+
+	if to != "" {
+		apiTo = strings.ReplaceAll(to, "-", "") + "235959"
+	} else {
+		apiTo = now.Format("20060102") + "235959"
+	}
+
+	return apiFrom, apiTo
+}
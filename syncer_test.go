@@ -0,0 +1,329 @@
+package htf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type mapCache map[string]bool
+
+func (c mapCache) Has(key string) bool { return c[key] }
+func (c mapCache) Add(key string)      { c[key] = true }
+
+// rejectingSink fails writes of the readings at the given times.
+type rejectingSink struct {
+	*FileSink
+	reject map[time.Time]error
+}
+
+func (s *rejectingSink) WriteMeasurement(ctx context.Context, m Measurement) error {
+	if err := s.reject[m.Time]; err != nil {
+		return err
+	}
+	return s.FileSink.WriteMeasurement(ctx, m)
+}
+
+func TestSyncer(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	t1 := time.Date(2023, 1, 1, 7, 0, 0, 0, tz).UTC()
+	t2 := t1.AddDate(0, 0, 1)
+	t3 := t1.AddDate(0, 0, 2)
+	t4 := t1.AddDate(0, 0, 3)
+	source := SourceFunc(func(ctx context.Context) (AggregatedInnerScanDataMap, error) {
+		return AggregatedInnerScanDataMap{
+			t1: {Weight: f(70.1)},
+			t2: {Weight: f(70.2)},
+			t3: {Weight: f(70.3)},
+			t4: {Weight: f(70.4)},
+		}, nil
+	})
+
+	ctx := context.Background()
+	file := &FileSink{Path: filepath.Join(t.TempDir(), "sink.jsonl")}
+	// t2 is already in the sink, t1 in the cache
+	if err := file.WriteMeasurement(ctx, Measurement{Time: t2, Weight: f(70.2)}); err != nil {
+		t.Fatal(err)
+	}
+	cache := mapCache{DailyKey(AggregatePolicyAll, t1): true}
+	sink := &rejectingSink{FileSink: file, reject: map[time.Time]error{
+		t3: &APIError{Provider: "test", StatusCode: http.StatusBadRequest, msg: "bad request"},
+	}}
+
+	outcomes := map[time.Time]string{}
+	var order []time.Time
+	record := func(r ReadingResult) {
+		outcomes[r.Time] = r.Outcome
+		order = append(order, r.Time)
+	}
+	fetched := 0
+	syncer := &Syncer{
+		Source:    source,
+		Sink:      sink,
+		Cache:     cache,
+		Now:       func() time.Time { return t4 },
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		OnFetched: func(data AggregatedInnerScanDataMap) { fetched = len(data) },
+		OnCreated: record,
+		OnSkipped: record,
+		OnError:   record,
+	}
+
+	result, err := syncer.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if fetched != 4 || len(result.Data) != 4 {
+		t.Errorf("fetched = %d, result.Data = %d, want 4", fetched, len(result.Data))
+	}
+	if result.Created != 1 || len(result.Readings) != 4 {
+		t.Errorf("Created = %d, Readings = %d", result.Created, len(result.Readings))
+	}
+
+	want := map[time.Time]string{
+		t1: OutcomeSkippedCache,
+		t2: OutcomeSkippedExisting,
+		t3: OutcomeRejected,
+		t4: OutcomeCreated,
+	}
+	for k, v := range want {
+		if outcomes[k] != v {
+			t.Errorf("outcome at %s = %q, want %q", k, outcomes[k], v)
+		}
+	}
+	if !slices.Equal(order, []time.Time{t1, t2, t3, t4}) {
+		t.Errorf("order = %v, want chronological", order)
+	}
+	if !result.SyncedThrough.Equal(t4) || !result.Complete() {
+		t.Errorf("SyncedThrough = %v, want %v, Complete() = %v", result.SyncedThrough, t4, result.Complete())
+	}
+	if !cache.Has(DailyKey(AggregatePolicyAll, t2)) || !cache.Has(DailyKey(AggregatePolicyAll, t4)) || cache.Has(DailyKey(AggregatePolicyAll, t3)) {
+		t.Errorf("cache = %v", cache)
+	}
+
+	// A failure that is not a rejection stops the run
+	sink.reject = map[time.Time]error{t3: &APIError{Provider: "test", StatusCode: http.StatusTooManyRequests, msg: "rate limited"}}
+	result, err = syncer.Run(ctx)
+	if err == nil || result.Err != err {
+		t.Fatalf("Run() error = %v, result.Err = %v", err, result.Err)
+	}
+	if StatusCode(err) != http.StatusTooManyRequests {
+		t.Errorf("StatusCode() = %d", StatusCode(err))
+	}
+	if !result.SyncedThrough.Equal(t2) || result.Complete() {
+		t.Errorf("SyncedThrough = %v, want %v, Complete() = %v", result.SyncedThrough, t2, result.Complete())
+	}
+
+	// Newest first stops at t3 without settling t1, so the watermark stays
+	order = nil
+	syncer.NewestFirst = true
+	result, _ = syncer.Run(ctx)
+	if !slices.Equal(order, []time.Time{t4, t3}) || !result.SyncedThrough.IsZero() {
+		t.Errorf("order = %v, SyncedThrough = %v", order, result.SyncedThrough)
+	}
+	syncer.NewestFirst = false
+
+	// An interrupted run stops before writing anything
+	sink.reject = nil
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	result, err = syncer.Run(cancelled)
+	if !errors.Is(err, context.Canceled) || result.Created != 0 {
+		t.Errorf("Run(cancelled) = %d created, error %v", result.Created, err)
+	}
+}
+
+func TestHealthPlanetSource_WholeDays(t *testing.T) {
+	var ranges []string
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		q := req.URL.Query()
+		body := `{"data": []}`
+		if q.Get("tag") == "6021" {
+			ranges = append(ranges, q.Get("date")+" "+q.Get("from")+"-"+q.Get("to"))
+			if q.Get("date") == "0" {
+				// Registered in the range: a reading taken the evening before
+				body = `{"data": [{"date": "202301022200", "keydata": "70.9", "tag": "6021"}]}`
+			} else {
+				body = `{"data": [
+					{"date": "202301020700", "keydata": "70.1", "tag": "6021"},
+					{"date": "202301022200", "keydata": "70.9", "tag": "6021"},
+					{"date": "202301030700", "keydata": "70.3", "tag": "6021"}
+				]}`
+			}
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewBufferString(body)), Header: make(http.Header)}
+	})
+	api := &HealthPlanetAPI{AccessToken: "test_token", Client: client, DateMode: DateModeRegistration}
+	ctx := context.Background()
+
+	source := &HealthPlanetSource{API: api, From: "20230103060000", To: "20230103080000", WholeDays: true}
+	data, err := source.Fetch(ctx)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	want := []time.Time{time.Date(2023, 1, 2, 7, 0, 0, 0, tz).UTC(), time.Date(2023, 1, 2, 22, 0, 0, 0, tz).UTC()}
+	if got := data.Times(); !slices.EqualFunc(got, want, time.Time.Equal) {
+		t.Errorf("Times() = %v, want the whole day of the registered reading %v", got, want)
+	}
+	if wantRanges := []string{"0 20230103060000-20230103080000", "1 20230102000000-20230102235959"}; !slices.Equal(ranges, wantRanges) {
+		t.Errorf("requests = %v, want %v", ranges, wantRanges)
+	}
+	if api.DateMode != DateModeRegistration {
+		t.Errorf("DateMode = %q, want it unchanged", api.DateMode)
+	}
+
+	// Without WholeDays only the registered reading is fetched
+	ranges = nil
+	source.WholeDays = false
+	data, err = source.Fetch(ctx)
+	if err != nil || len(data) != 1 || len(ranges) != 1 {
+		t.Errorf("Fetch() = %d readings in %v, %v", len(data), ranges, err)
+	}
+}
+
+func TestHealthPlanetDateRange(t *testing.T) {
+	now := time.Date(2023, 5, 10, 12, 0, 0, 0, tz)
+
+	from, to := HealthPlanetDateRange("2023-01-01", "2023-01-31", now)
+	if from != "20230101000000" || to != "20230131235959" {
+		t.Errorf("HealthPlanetDateRange() = %s, %s", from, to)
+	}
+
+	from, to = HealthPlanetDateRange("", "", now)
+	if from != "20230210000000" || to != "20230510235959" {
+		t.Errorf("HealthPlanetDateRange(default) = %s, %s", from, to)
+	}
+}
+
+// metricSink stores metrics separately and fails writes of the metrics in
+// fail.
+type metricSink struct {
+	*FileSink
+	logs    map[string]string
+	fail    map[string]error
+	writes  []string
+	deleted []string
+}
+
+func (s *metricSink) HasMetric(ctx context.Context, policy, metric string, t time.Time) (bool, error) {
+	_, ok := s.logs[MetricKey(DailyKey(policy, t), metric)]
+	return ok, nil
+}
+
+func (s *metricSink) WriteMetric(ctx context.Context, metric string, m Measurement) (string, error) {
+	if err := s.fail[metric]; err != nil {
+		return "", err
+	}
+	s.writes = append(s.writes, metric)
+	id := strconv.Itoa(len(s.writes))
+	s.logs[MetricKey(DailyKey(AggregatePolicyAll, m.Time), metric)] = id
+	return id, nil
+}
+
+func (s *metricSink) DeleteMetric(ctx context.Context, metric, id string) error {
+	s.deleted = append(s.deleted, metric+" "+id)
+	for k, v := range s.logs {
+		if v == id {
+			delete(s.logs, k)
+		}
+	}
+	return nil
+}
+
+func TestSyncer_Metrics(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	t1 := time.Date(2023, 1, 1, 7, 0, 0, 0, tz).UTC()
+	source := SourceFunc(func(ctx context.Context) (AggregatedInnerScanDataMap, error) {
+		return AggregatedInnerScanDataMap{t1: {Weight: f(70.1), Fat: f(20.1)}}, nil
+	})
+	serverError := &APIError{Provider: "test", StatusCode: http.StatusServiceUnavailable, msg: "unavailable"}
+	ctx := context.Background()
+
+	t.Run("retry missing", func(t *testing.T) {
+		sink := &metricSink{FileSink: &FileSink{}, logs: map[string]string{}, fail: map[string]error{MetricFat: serverError}}
+		cache := mapCache{}
+		syncer := &Syncer{Source: source, Sink: sink, Cache: cache, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+		result, err := syncer.Run(ctx)
+		if err == nil {
+			t.Fatal("Run() error = nil")
+		}
+		if m := result.Readings[0].Metrics; m[MetricWeight] != OutcomeCreated || m[MetricFat] != OutcomeFailed {
+			t.Errorf("Metrics = %v", m)
+		}
+
+		// The next run writes only the fat
+		sink.fail = nil
+		result, err = syncer.Run(ctx)
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if !slices.Equal(sink.writes, []string{MetricWeight, MetricFat}) {
+			t.Errorf("writes = %v", sink.writes)
+		}
+		if m := result.Readings[0].Metrics; m[MetricWeight] != OutcomeSkippedCache || m[MetricFat] != OutcomeCreated {
+			t.Errorf("Metrics = %v", m)
+		}
+		if !cache.Has(DailyKey(AggregatePolicyAll, t1)) {
+			t.Error("reading is not cached")
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		sink := &metricSink{FileSink: &FileSink{}, logs: map[string]string{}, fail: map[string]error{MetricFat: serverError}}
+		cache := mapCache{}
+		syncer := &Syncer{Source: source, Sink: sink, Cache: cache, Rollback: true, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+		result, err := syncer.Run(ctx)
+		if err == nil {
+			t.Fatal("Run() error = nil")
+		}
+		if !slices.Equal(sink.deleted, []string{"weight 1"}) || len(sink.logs) != 0 {
+			t.Errorf("deleted = %v, logs = %v", sink.deleted, sink.logs)
+		}
+		if m := result.Readings[0].Metrics; m[MetricWeight] != OutcomeRolledBack {
+			t.Errorf("Metrics = %v", m)
+		}
+		if len(cache) != 0 {
+			t.Errorf("cache = %v, want empty", cache)
+		}
+	})
+	t.Run("retry", func(t *testing.T) {
+		sink := &metricSink{FileSink: &FileSink{}, logs: map[string]string{}, fail: map[string]error{MetricFat: serverError}}
+		// The fat is written on its second attempt
+		calls := 0
+		retrying := &retryingSink{metricSink: sink, before: func() {
+			if calls++; calls == 3 {
+				sink.fail = nil
+			}
+		}}
+		syncer := &Syncer{Source: source, Sink: retrying, Retry: &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+		result, err := syncer.Run(ctx)
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if result.Created != 1 || !slices.Equal(sink.writes, []string{MetricWeight, MetricFat}) {
+			t.Errorf("Created = %d, writes = %v", result.Created, sink.writes)
+		}
+	})
+}
+
+// retryingSink calls before on every write.
+type retryingSink struct {
+	*metricSink
+	before func()
+}
+
+func (s *retryingSink) WriteMetric(ctx context.Context, metric string, m Measurement) (string, error) {
+	s.before()
+	return s.metricSink.WriteMetric(ctx, metric, m)
+}
@@ -0,0 +1,51 @@
+package htf
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// PersistingTokenSource returns the tokens of Source and passes every new
+// one to Save before returning it. Fitbit refresh tokens can be used only
+// once, so a refreshed token has to be stored before it is used: otherwise a
+// crash leaves only the spent refresh token behind.
+//
+// If Save fails, Token returns the error. Source is expected to reuse the
+// token until it expires (as oauth2.ReuseTokenSource does), so the next call
+// saves it again.
+type PersistingTokenSource struct {
+	Source oauth2.TokenSource
+	Save   func(token *oauth2.Token) error
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+// NewPersistingTokenSource returns a PersistingTokenSource for the tokens of
+// src, where current is the token that is already stored.
+func NewPersistingTokenSource(src oauth2.TokenSource, current *oauth2.Token, save func(token *oauth2.Token) error) *PersistingTokenSource {
+	return &PersistingTokenSource{Source: src, Save: save, last: current}
+}
+
+func (s *PersistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.Source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.last != nil && token.AccessToken == s.last.AccessToken && token.RefreshToken == s.last.RefreshToken {
+		return token, nil
+	}
+
+	if err := s.Save(token); err != nil {
+		return nil, errors.Wrap(err, "failed to save refreshed token")
+	}
+	s.last = token
+
+	return token, nil
+}
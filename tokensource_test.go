@@ -0,0 +1,93 @@
+package htf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) { return f() }
+
+func TestPersistingTokenSource(t *testing.T) {
+	current := &oauth2.Token{AccessToken: "a1", RefreshToken: "r1"}
+	next := current
+	src := tokenSourceFunc(func() (*oauth2.Token, error) { return next, nil })
+
+	var saved []string
+	saveErr := error(nil)
+	ts := NewPersistingTokenSource(src, current, func(token *oauth2.Token) error {
+		if saveErr != nil {
+			return saveErr
+		}
+		saved = append(saved, token.RefreshToken)
+		return nil
+	})
+
+	// The stored token is not saved again
+	if _, err := ts.Token(); err != nil || len(saved) != 0 {
+		t.Fatalf("Token() error = %v, saved = %v", err, saved)
+	}
+
+	// A failed save keeps the token from being used and is retried
+	next = &oauth2.Token{AccessToken: "a2", RefreshToken: "r2"}
+	saveErr = errors.New("disk full")
+	if _, err := ts.Token(); err == nil {
+		t.Fatal("Token() error = nil, want the save error")
+	}
+	saveErr = nil
+	token, err := ts.Token()
+	if err != nil || token.RefreshToken != "r2" {
+		t.Fatalf("Token() = %v, %v", token, err)
+	}
+	if _, err := ts.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0] != "r2" {
+		t.Errorf("saved = %v, want [r2]", saved)
+	}
+}
+
+func TestNewFitbitAPI_SavesRefreshedToken(t *testing.T) {
+	var events []string
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		body := `{"weight": []}`
+		if req.URL.Path == "/oauth2/token" {
+			events = append(events, "refresh")
+			body = `{"access_token": "new_access", "refresh_token": "new_refresh", "token_type": "Bearer", "expires_in": 28800}`
+		} else {
+			events = append(events, "call "+req.Header.Get("Authorization"))
+		}
+		header := make(http.Header)
+		header.Set("Content-Type", "application/json")
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewBufferString(body)), Header: header}
+	})
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+
+	expired := &oauth2.Token{AccessToken: "old_access", RefreshToken: "old_refresh", Expiry: time.Now().Add(-time.Hour)}
+	api := NewFitbitAPI(ctx, "id", "secret", expired, func(token *oauth2.Token) error {
+		events = append(events, "save "+token.RefreshToken)
+		return nil
+	})
+
+	if _, err := api.GetBodyWeightLog(ctx, time.Now()); err != nil {
+		t.Fatalf("GetBodyWeightLog() error = %v", err)
+	}
+	want := []string{"refresh", "save new_refresh", "call Bearer new_access"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("events = %v, want %v", events, want)
+			break
+		}
+	}
+}
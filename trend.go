@@ -0,0 +1,154 @@
+package htf
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultTrendAlpha is the smoothing factor used by "The Hacker's Diet":
+// each reading moves the trend 10% of the way towards it.
+const DefaultTrendAlpha = 0.1
+
+// WeightTrend returns the exponentially weighted moving average of weights,
+// computed in time order and keyed by the time of each reading. alpha must be
+// in (0, 1]; larger values follow the readings more closely.
+func WeightTrend(weights map[time.Time]float64, alpha float64) (map[time.Time]float64, error) {
+	if alpha <= 0 || alpha > 1 {
+		return nil, errors.Errorf("trend alpha must be in (0, 1]: %v", alpha)
+	}
+
+	times := make([]time.Time, 0, len(weights))
+	for t := range weights {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	trend := make(map[time.Time]float64, len(weights))
+	var prev float64
+	for i, t := range times {
+		if i == 0 {
+			prev = weights[t]
+		} else {
+			prev += alpha * (weights[t] - prev)
+		}
+		trend[t] = math.Round(prev*100) / 100
+	}
+
+	return trend, nil
+}
+
+// UpdateLedgerTrend recomputes the weight trend over every reading in the
+// ledger and rewrites it if any value changed. Since the whole history is
+// recomputed, readings that arrive out of order are folded in correctly.
+func UpdateLedgerTrend(ledger *FileSink, alpha float64) (map[time.Time]float64, error) {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+
+	ms, err := ledger.readAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read ledger")
+	}
+
+	weights := make(map[time.Time]float64, len(ms))
+	for _, m := range ms {
+		if m.Weight != nil {
+			weights[m.Time] = *m.Weight
+		}
+	}
+	trend, err := WeightTrend(weights, alpha)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+	for i, m := range ms {
+		v, ok := trend[m.Time]
+		switch {
+		case !ok && m.WeightTrend == nil:
+		case ok && m.WeightTrend != nil && *m.WeightTrend == v:
+		case !ok:
+			ms[i].WeightTrend = nil
+			changed = true
+		default:
+			ms[i].WeightTrend = &v
+			changed = true
+		}
+	}
+	if changed {
+		if err := ledger.writeAll(ms); err != nil {
+			return nil, errors.Wrap(err, "failed to rewrite ledger")
+		}
+	}
+
+	return trend, nil
+}
+
+// PushTrend sets the weight trend of the measurements sink holds to the
+// values in trend, and returns the number of measurements updated. A reading
+// that arrives late changes the trend of every later reading, which SyncSink
+// does not rewrite since the sink already has them. Sinks implementing
+// MeasurementUpdater are updated in place; others have each stale
+// measurement deleted and written again.
+func PushTrend(ctx context.Context, sink Sink, trend map[time.Time]float64) (int, error) {
+	if len(trend) == 0 {
+		return 0, nil
+	}
+
+	byUnix := make(map[int64]float64, len(trend))
+	var from, to time.Time
+	for t, v := range trend {
+		byUnix[t.Unix()] = v
+		if from.IsZero() || t.Before(from) {
+			from = t
+		}
+		if to.IsZero() || t.After(to) {
+			to = t
+		}
+	}
+
+	existing, err := sink.ListMeasurements(ctx, from, to)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to list measurements in %s", sink.Name())
+	}
+	var stale []Measurement
+	for _, m := range existing {
+		v, ok := byUnix[m.Time.Unix()]
+		if !ok || (m.WeightTrend != nil && *m.WeightTrend == v) {
+			continue
+		}
+		m.WeightTrend = &v
+		stale = append(stale, m)
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	if u, ok := sink.(MeasurementUpdater); ok {
+		if err := u.UpdateMeasurements(ctx, stale); err != nil {
+			return 0, errors.Wrapf(err, "failed to update trend in %s", sink.Name())
+		}
+		return len(stale), nil
+	}
+	for i, m := range stale {
+		if err := sink.DeleteMeasurement(ctx, m); err != nil {
+			return i, errors.Wrapf(err, "failed to update trend in %s", sink.Name())
+		}
+		if err := sink.WriteMeasurement(ctx, m); err != nil {
+			return i, errors.Wrapf(err, "failed to update trend in %s", sink.Name())
+		}
+	}
+	return len(stale), nil
+}
+
+// ApplyTrend sets WeightTrend on every reading that has a value in trend.
+func (m AggregatedInnerScanDataMap) ApplyTrend(trend map[time.Time]float64) {
+	for t, d := range m {
+		if v, ok := trend[t]; ok {
+			d.WeightTrend = &v
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package htf
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeightTrend(t *testing.T) {
+	t1 := time.Date(2023, 1, 1, 7, 0, 0, 0, tz)
+	t2 := t1.AddDate(0, 0, 1)
+	t3 := t1.AddDate(0, 0, 2)
+
+	trend, err := WeightTrend(map[time.Time]float64{t3: 72, t1: 70, t2: 71}, 0.5)
+	if err != nil {
+		t.Fatalf("WeightTrend() error = %v", err)
+	}
+	want := map[time.Time]float64{t1: 70, t2: 70.5, t3: 71.25}
+	for k, v := range want {
+		if trend[k] != v {
+			t.Errorf("trend[%s] = %v, want %v", k, trend[k], v)
+		}
+	}
+
+	for _, alpha := range []float64{0, -0.1, 1.5} {
+		if _, err := WeightTrend(nil, alpha); err == nil {
+			t.Errorf("WeightTrend(alpha=%v) error = nil", alpha)
+		}
+	}
+}
+
+func TestUpdateLedgerTrend(t *testing.T) {
+	for _, format := range []string{FileFormatJSONLines, FileFormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			ledger := &FileSink{
+				Path:   filepath.Join(t.TempDir(), "ledger."+format),
+				Format: format,
+			}
+			ctx := context.Background()
+
+			t1 := time.Date(2023, 1, 1, 7, 0, 0, 0, tz).UTC()
+			t2 := t1.AddDate(0, 0, 1)
+			t3 := t1.AddDate(0, 0, 2)
+			w1, w2, w3 := 70.0, 71.0, 72.0
+
+			if _, err := SyncSink(ctx, ledger, AggregatedInnerScanDataMap{t1: {Weight: &w1}, t3: {Weight: &w3}}); err != nil {
+				t.Fatalf("SyncSink() error = %v", err)
+			}
+			trend, err := UpdateLedgerTrend(ledger, 0.5)
+			if err != nil {
+				t.Fatalf("UpdateLedgerTrend() error = %v", err)
+			}
+			if trend[t3] != 71 {
+				t.Errorf("trend[t3] = %v, want 71", trend[t3])
+			}
+
+			// A late reading in between must be folded into the later trend
+			if _, err := SyncSink(ctx, ledger, AggregatedInnerScanDataMap{t2: {Weight: &w2}}); err != nil {
+				t.Fatalf("SyncSink() error = %v", err)
+			}
+			if _, err := UpdateLedgerTrend(ledger, 0.5); err != nil {
+				t.Fatalf("UpdateLedgerTrend() error = %v", err)
+			}
+
+			ms, err := ledger.ListMeasurements(ctx, t1, t3)
+			if err != nil {
+				t.Fatalf("ListMeasurements() error = %v", err)
+			}
+			got := map[time.Time]float64{}
+			for _, m := range ms {
+				if m.WeightTrend == nil {
+					t.Fatalf("measurement at %s has no trend", m.Time)
+				}
+				got[m.Time] = *m.WeightTrend
+			}
+			want := map[time.Time]float64{t1: 70, t2: 70.5, t3: 71.25}
+			for k, v := range want {
+				if got[k] != v {
+					t.Errorf("trend[%s] = %v, want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestPushTrend(t *testing.T) {
+	t1 := time.Date(2023, 1, 1, 7, 0, 0, 0, tz).UTC()
+	t2 := t1.AddDate(0, 0, 1)
+	t3 := t1.AddDate(0, 0, 2)
+	w1, w2, w3 := 70.0, 71.0, 72.0
+	ctx := context.Background()
+
+	sinks := map[string]Sink{
+		"file":   &FileSink{Path: filepath.Join(t.TempDir(), "pushed.csv"), Format: FileFormatCSV},
+		"delete": &memorySink{},
+	}
+	for name, sink := range sinks {
+		t.Run(name, func(t *testing.T) {
+			ledger := &FileSink{Path: filepath.Join(t.TempDir(), "ledger.jsonl")}
+			push := func(data AggregatedInnerScanDataMap) {
+				if _, err := SyncSink(ctx, ledger, data); err != nil {
+					t.Fatalf("SyncSink(ledger) error = %v", err)
+				}
+				trend, err := UpdateLedgerTrend(ledger, 0.5)
+				if err != nil {
+					t.Fatalf("UpdateLedgerTrend() error = %v", err)
+				}
+				data.ApplyTrend(trend)
+				if _, err := SyncSink(ctx, sink, data); err != nil {
+					t.Fatalf("SyncSink() error = %v", err)
+				}
+				if _, err := PushTrend(ctx, sink, trend); err != nil {
+					t.Fatalf("PushTrend() error = %v", err)
+				}
+			}
+
+			push(AggregatedInnerScanDataMap{t1: {Weight: &w1}, t3: {Weight: &w3}})
+			// The late reading changes the trend at t3, which the sink has
+			push(AggregatedInnerScanDataMap{t2: {Weight: &w2}})
+
+			ms, err := sink.ListMeasurements(ctx, t1, t3)
+			if err != nil {
+				t.Fatalf("ListMeasurements() error = %v", err)
+			}
+			if len(ms) != 3 {
+				t.Fatalf("ListMeasurements() = %+v, want 3 measurements", ms)
+			}
+			want := map[time.Time]float64{t1: 70, t2: 70.5, t3: 71.25}
+			for _, m := range ms {
+				if m.WeightTrend == nil || *m.WeightTrend != want[m.Time.UTC()] {
+					t.Errorf("trend at %s = %v, want %v", m.Time, m.WeightTrend, want[m.Time.UTC()])
+				}
+			}
+
+			if n, err := PushTrend(ctx, sink, map[time.Time]float64{t1: 70, t2: 70.5, t3: 71.25}); n != 0 || err != nil {
+				t.Errorf("PushTrend() = %d, %v, want nothing to update", n, err)
+			}
+		})
+	}
+}
+
+// memorySink is a sink without MeasurementUpdater.
+type memorySink struct {
+	ms []Measurement
+}
+
+func (s *memorySink) Name() string { return "memory" }
+
+func (s *memorySink) WriteMeasurement(ctx context.Context, m Measurement) error {
+	s.ms = append(s.ms, m)
+	return nil
+}
+
+func (s *memorySink) ListMeasurements(ctx context.Context, from, to time.Time) ([]Measurement, error) {
+	var ms []Measurement
+	for _, m := range s.ms {
+		if !m.Time.Before(from) && !m.Time.After(to) {
+			ms = append(ms, m)
+		}
+	}
+	return ms, nil
+}
+
+func (s *memorySink) DeleteMeasurement(ctx context.Context, m Measurement) error {
+	s.ms = slices.DeleteFunc(s.ms, func(e Measurement) bool { return e.Time.Equal(m.Time) })
+	return nil
+}
+
+func TestFileSinkCSVWithoutTrend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.csv")
+	content := strings.Join([]string{"time,weight,fat", "2023-01-01T03:00:00Z,70.5,20.5", ""}, "\n")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &FileSink{Path: path, Format: FileFormatCSV}
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms, err := sink.ListMeasurements(context.Background(), from, from.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("ListMeasurements() error = %v", err)
+	}
+	if len(ms) != 1 || ms[0].Weight == nil || *ms[0].Weight != 70.5 || ms[0].WeightTrend != nil {
+		t.Errorf("ListMeasurements() = %+v", ms)
+	}
+}
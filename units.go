@@ -0,0 +1,125 @@
+package htf
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Weight units. HealthPlanet always reports kilograms.
+const (
+	WeightUnitKg = "kg"
+	WeightUnitLb = "lb"
+)
+
+// Rounding modes.
+const (
+	RoundingNearest = "nearest"
+	RoundingDown    = "down"
+	RoundingUp      = "up"
+)
+
+const kgPerLb = 0.45359237
+
+// ParseWeightUnit validates a weight unit.
+func ParseWeightUnit(s string) (string, error) {
+	switch s {
+	case WeightUnitKg, WeightUnitLb:
+		return s, nil
+	default:
+		return "", errors.Errorf("unknown weight unit: %s", s)
+	}
+}
+
+// ConvertWeight converts a weight in kilograms to unit.
+func ConvertWeight(kg float64, unit string) float64 {
+	if unit == WeightUnitLb {
+		return kg / kgPerLb
+	}
+	return kg
+}
+
+// WeightToKg converts a weight in unit to kilograms.
+func WeightToKg(v float64, unit string) float64 {
+	if unit == WeightUnitLb {
+		return v * kgPerLb
+	}
+	return v
+}
+
+// Rounding is how values are rounded before they are written to Fitbit.
+type Rounding struct {
+	Mode     string
+	Decimals int
+}
+
+// DefaultRounding matches the precision Fitbit stores.
+var DefaultRounding = Rounding{Mode: RoundingNearest, Decimals: 2}
+
+// Validate checks the rounding mode.
+func (r Rounding) Validate() error {
+	switch r.Mode {
+	case RoundingNearest, RoundingDown, RoundingUp:
+	default:
+		return errors.Errorf("unknown rounding mode: %s", r.Mode)
+	}
+	if r.Decimals < 0 {
+		return errors.Errorf("rounding decimals must not be negative: %d", r.Decimals)
+	}
+	return nil
+}
+
+// Round rounds v to r.Decimals decimal places.
+func (r Rounding) Round(v float64) float64 {
+	p := math.Pow(10, float64(r.Decimals))
+	// Trim floating point noise so 70.1 is not rounded up to 70.11
+	x := math.Round(v*p*1e6) / 1e6
+	switch r.Mode {
+	case RoundingDown:
+		return math.Floor(x) / p
+	case RoundingUp:
+		return math.Ceil(x) / p
+	default:
+		return math.Round(x) / p
+	}
+}
+
+// FitbitProfile is the part of the Fitbit user profile this tool uses.
+type FitbitProfile struct {
+	User struct {
+		// WeightUnit is the locale of the weight unit, e.g. "METRIC" or "en_US".
+		WeightUnit string  `json:"weightUnit"`
+		Height     float64 `json:"height"`
+		HeightUnit string  `json:"heightUnit"`
+	} `json:"user"`
+}
+
+// PreferredWeightUnit returns the weight unit the account displays.
+func (p *FitbitProfile) PreferredWeightUnit() string {
+	if p.User.WeightUnit == "en_US" {
+		return WeightUnitLb
+	}
+	return WeightUnitKg
+}
+
+// GetProfile returns the profile of the user. It requires the profile scope.
+func (api *FitbitAPI) GetProfile(ctx context.Context) (*FitbitProfile, error) {
+	res, err := api.do(ctx, "GET", "https://api.fitbit.com/1/user/-/profile.json")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get profile in fitbit")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		return nil, fitbitStatusError(res, "get profile")
+	}
+
+	var profile FitbitProfile
+	if err := json.NewDecoder(res.Body).Decode(&profile); err != nil {
+		return nil, errors.Wrap(err, "failed to parse profile in fitbit")
+	}
+
+	return &profile, nil
+}
@@ -0,0 +1,154 @@
+package htf
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConvertWeight(t *testing.T) {
+	if got := ConvertWeight(70, WeightUnitLb); math.Abs(got-154.3236) > 0.0001 {
+		t.Errorf("ConvertWeight(70, lb) = %v", got)
+	}
+	if got := ConvertWeight(70, WeightUnitKg); got != 70 {
+		t.Errorf("ConvertWeight(70, kg) = %v", got)
+	}
+	if got := WeightToKg(ConvertWeight(70, WeightUnitLb), WeightUnitLb); math.Abs(got-70) > 1e-9 {
+		t.Errorf("round trip = %v", got)
+	}
+	if _, err := ParseWeightUnit("st"); err == nil {
+		t.Error("ParseWeightUnit(st) error = nil")
+	}
+}
+
+func TestRounding(t *testing.T) {
+	tests := []struct {
+		r    Rounding
+		v    float64
+		want float64
+	}{
+		{Rounding{RoundingNearest, 2}, 154.3236, 154.32},
+		{Rounding{RoundingNearest, 1}, 154.35, 154.4},
+		{Rounding{RoundingDown, 1}, 154.39, 154.3},
+		{Rounding{RoundingUp, 1}, 154.31, 154.4},
+		{Rounding{RoundingUp, 1}, 70.1, 70.1},
+		{Rounding{RoundingNearest, 0}, 154.5, 155},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Round(tt.v); got != tt.want {
+			t.Errorf("%+v.Round(%v) = %v, want %v", tt.r, tt.v, got, tt.want)
+		}
+	}
+
+	if err := (Rounding{Mode: "banker"}).Validate(); err == nil {
+		t.Error("Validate() error = nil for unknown mode")
+	}
+}
+
+func TestFitbitAPI_WeightUnit(t *testing.T) {
+	var requests []*http.Request
+	api := &FitbitAPI{
+		WeightUnit: WeightUnitLb,
+		Client: NewTestClient(func(req *http.Request) *http.Response {
+			requests = append(requests, req)
+			body := `{}`
+			if req.Method == "GET" {
+				body = `{"weight":[{"date":"2023-01-01","time":"03:00:00","weight":154.32,"logId":1}]}`
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+				Header:     make(http.Header),
+			}
+		}),
+	}
+	ctx := context.Background()
+	ts := time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC)
+	weight := 70.0
+
+	if err := api.WriteMeasurement(ctx, Measurement{Time: ts, Weight: &weight}); err != nil {
+		t.Fatalf("WriteMeasurement() error = %v", err)
+	}
+	if got := requests[0].URL.Query().Get("weight"); got != "154.32" {
+		t.Errorf("weight = %s, want 154.32", got)
+	}
+	if got := requests[0].Header.Get("Accept-Language"); got != "en_US" {
+		t.Errorf("Accept-Language = %q, want en_US", got)
+	}
+
+	ms, err := api.ListMeasurements(ctx, ts, ts)
+	if err != nil {
+		t.Fatalf("ListMeasurements() error = %v", err)
+	}
+	if len(ms) != 1 || math.Abs(*ms[0].Weight-70) > 0.01 {
+		t.Errorf("ListMeasurements() = %+v", ms)
+	}
+}
+
+func TestGetProfile(t *testing.T) {
+	api := &FitbitAPI{Client: NewTestClient(func(req *http.Request) *http.Response {
+		if req.URL.Path != "/1/user/-/profile.json" {
+			t.Errorf("path = %s", req.URL.Path)
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewBufferString(`{"user":{"weightUnit":"en_US","height":170.2}}`)),
+			Header:     make(http.Header),
+		}
+	})}
+
+	profile, err := api.GetProfile(context.Background())
+	if err != nil {
+		t.Fatalf("GetProfile() error = %v", err)
+	}
+	if profile.PreferredWeightUnit() != WeightUnitLb {
+		t.Errorf("PreferredWeightUnit() = %s, want lb", profile.PreferredWeightUnit())
+	}
+}
+
+func TestAudit_Pounds(t *testing.T) {
+	ts := time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC)
+	weight := 70.0
+	data := AggregatedInnerScanDataMap{ts: {Weight: &weight}}
+	logs := []FitbitBodyLog{{Type: FitbitLogTypeWeight, Time: ts, Value: 154.32, Unit: WeightUnitLb}}
+
+	report := Audit(data, logs)
+	if len(report.Mismatches) != 0 || len(report.MissingInFitbit) != 0 {
+		t.Errorf("Audit() = %+v", report)
+	}
+}
+
+func TestConvertUnitExportAndReport(t *testing.T) {
+	trend := 70.0
+	records := ConvertExportUnit([]ExportRecord{
+		{Tag: "6021", Value: 70, Unit: WeightUnitKg, Trend: &trend},
+		{Tag: "6022", Value: 20, Unit: "%"},
+	}, WeightUnitLb, DefaultRounding)
+	if records[0].Value != 154.32 || *records[0].Trend != 154.32 || records[0].Unit != WeightUnitLb {
+		t.Errorf("weight record = %+v", records[0])
+	}
+	if records[1].Value != 20 || records[1].Unit != "%" {
+		t.Errorf("fat record = %+v", records[1])
+	}
+
+	ts := time.Date(2023, 1, 2, 7, 0, 0, 0, tz)
+	from, to, _ := ReportPeriodRange(ReportPeriodWeek, ts)
+	fat := 20.0
+	r := BuildReport(ReportPeriodWeek, from, to, []Measurement{{Time: ts, Weight: &trend, Fat: &fat}}, nil).InUnit(WeightUnitLb)
+	for _, m := range r.Metrics {
+		switch m.Name {
+		case "Weight":
+			if m.Unit != WeightUnitLb || math.Abs(m.Mean-154.32) > 0.01 {
+				t.Errorf("weight stats = %+v", m)
+			}
+		case "Body fat":
+			if m.Unit != "%" || m.Mean != 20 {
+				t.Errorf("fat stats = %+v", m)
+			}
+		}
+	}
+}